@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"bdaggregator/internal/config"
+	"bdaggregator/internal/currency"
+)
+
+const cacheUsage = `usage:
+  bdagg cache inspect
+  bdagg cache purge [-coin <coin-id>] [-currency <currency>] [-before <YYYY-MM-DD>]`
+
+// Inspects or purges the on-disk exchange rate cache set with SEQUENCE_RATE_CACHE_DIR
+func runCacheCommand(cfg *config.Config, args []string) {
+	if cfg.RateCacheDir == "" {
+		log.Fatalf("rate cache is disabled, set SEQUENCE_RATE_CACHE_DIR")
+	}
+	if len(args) == 0 {
+		log.Fatal(cacheUsage)
+	}
+	cache := currency.NewRateCache(cfg.RateCacheDir)
+
+	switch args[0] {
+	case "inspect":
+		entries, err := cache.Inspect()
+		if err != nil {
+			log.Fatalf("failed to inspect rate cache: %v", err)
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "Coin\tCurrency\tDays\tRates\tFrom\tTo\tSize")
+		for _, entry := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%d\n", entry.CoinID, entry.Currency, entry.Buckets, entry.Rates,
+				entry.From.Format("2006-01-02"), entry.To.Format("2006-01-02"), entry.Size)
+		}
+		w.Flush()
+
+	case "purge":
+		flags := flag.NewFlagSet("cache purge", flag.ExitOnError)
+		coinID := flags.String("coin", "", "purge only this coin ID")
+		targetCurrency := flags.String("currency", "", "purge only this target currency")
+		beforeDay := flags.String("before", "", "purge only days before this date (YYYY-MM-DD)")
+		flags.Parse(args[1:])
+
+		var before time.Time
+		if *beforeDay != "" {
+			var err error
+			if before, err = time.Parse("2006-01-02", *beforeDay); err != nil {
+				log.Fatalf("invalid -before date: %v", err)
+			}
+		}
+
+		removed, err := cache.Purge(*coinID, *targetCurrency, before)
+		if err != nil {
+			log.Fatalf("failed to purge rate cache: %v", err)
+		}
+		log.Printf("Removed %d cached days", removed)
+
+	default:
+		log.Fatal(cacheUsage)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"bdaggregator/internal/config"
+	"bdaggregator/internal/currency"
+	"bdaggregator/internal/currency/coingecko"
+)
+
+const coinsUsage = `usage:
+  bdagg coins refresh [-dry-run]`
+
+// Refreshes the coin list set with SEQUENCE_COINS_FILE_PATH from CoinGecko
+func runCoinsCommand(cfg *config.Config, args []string) {
+	if len(args) == 0 || args[0] != "refresh" {
+		log.Fatal(coinsUsage)
+	}
+
+	flags := flag.NewFlagSet("coins refresh", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "print the differences without writing the file")
+	flags.Parse(args[1:])
+
+	client, err := coingecko.NewClient(cfg)
+	if err != nil {
+		log.Fatalf("failed to create CoinGecko client: %v", err)
+	}
+	ctx := context.Background()
+
+	var diff currency.CoinListDiff
+	if *dryRun {
+		if _, diff, err = currency.DiffCoinList(ctx, client, cfg.CoinListPath); err != nil {
+			log.Fatalf("failed to diff coins: %v", err)
+		}
+	} else {
+		if _, diff, err = currency.RefreshCoinList(ctx, client, cfg.CoinListPath); err != nil {
+			log.Fatalf("failed to refresh coins: %v", err)
+		}
+	}
+
+	printCoinListDiff(diff)
+	switch {
+	case diff.Empty():
+		log.Printf("%s is up to date", cfg.CoinListPath)
+	case *dryRun:
+		log.Printf("%s: %s, not written in a dry run", cfg.CoinListPath, diff)
+	default:
+		log.Printf("%s updated: %s", cfg.CoinListPath, diff)
+	}
+}
+
+func printCoinListDiff(diff currency.CoinListDiff) {
+	for _, coin := range diff.Added {
+		fmt.Printf("+ %s (%s)\n", coin.ID, coin.Symbol)
+	}
+	for _, coin := range diff.Removed {
+		fmt.Printf("- %s (%s)\n", coin.ID, coin.Symbol)
+	}
+	for _, change := range diff.Changed {
+		fmt.Printf("~ %s: %s\n", change.ID, strings.Join(platformChanges(change), ", "))
+	}
+}
+
+// Describes each added, removed or moved platform address of a coin
+func platformChanges(change currency.CoinChange) []string {
+	platforms := make(map[string]bool)
+	for platform := range change.Before {
+		platforms[platform] = true
+	}
+	for platform := range change.After {
+		platforms[platform] = true
+	}
+
+	var changes []string
+	for platform := range platforms {
+		before, after := change.Before[platform], change.After[platform]
+		switch {
+		case platform == "" || before == after:
+		case before == "":
+			changes = append(changes, fmt.Sprintf("+%s %s", platform, after))
+		case after == "":
+			changes = append(changes, fmt.Sprintf("-%s %s", platform, before))
+		default:
+			changes = append(changes, fmt.Sprintf("%s %s -> %s", platform, before, after))
+		}
+	}
+	sort.Strings(changes)
+	return changes
+}
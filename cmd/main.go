@@ -3,10 +3,12 @@ package main
 import (
 	"context"
 	"log"
+	"os"
 	"time"
 
 	"bdaggregator/internal/config"
 	"bdaggregator/internal/currency"
+	"bdaggregator/internal/currency/coingecko"
 	"bdaggregator/internal/db"
 	"bdaggregator/internal/etl"
 	"bdaggregator/internal/storage"
@@ -16,19 +18,40 @@ func main() {
 	cfg := config.LoadConfig()
 	ctx := context.Background()
 
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "cache":
+			runCacheCommand(cfg, os.Args[2:])
+		case "coins":
+			runCoinsCommand(cfg, os.Args[2:])
+		case "rates":
+			runRatesCommand(cfg, os.Args[2:])
+		default:
+			log.Fatalf("unknown command: %s", os.Args[1])
+		}
+		return
+	}
+
 	// ------------------------ SETUP ------------------------------------------
 
 	storageClient, err := storage.NewStorage(cfg)
 	if err != nil {
 		log.Fatalf("failed to initialize storage: %v", err)
 	}
+	defer storageClient.Close()
+
+	// List the input objects (single file, prefix or glob) using the selected storage type
+	objectNames, err := storageClient.List()
+	if err != nil {
+		log.Fatalf("failed to list objects: %v", err)
+	}
+	log.Printf("Found %d objects to process", len(objectNames))
 
-	// Download the CSV file using the selected storage type
-	reader, err := storageClient.Download()
+	deadLetterWriter, err := storage.NewDeadLetterWriter(cfg, storageClient)
 	if err != nil {
-		log.Fatalf("failed to download file: %v", err)
+		log.Fatalf("failed to open dead letter output: %v", err)
 	}
-	log.Println("File downloaded successfully")
+	deadLetters := etl.NewDeadLetters(deadLetterWriter)
 
 	dbClient, err := db.NewDatabase(ctx, cfg)
 	if err != nil {
@@ -44,35 +67,146 @@ func main() {
 		log.Fatalf("failed to setup table: %v", err)
 	}
 
+	if err := dbClient.SetupTable(ctx, "rates"); err != nil {
+		log.Fatalf("failed to setup rates table: %v", err)
+	}
+
+	if cfg.EventsTable {
+		if err := dbClient.SetupTable(ctx, "events"); err != nil {
+			log.Fatalf("failed to setup events table: %v", err)
+		}
+	}
+
 	supported_coins, err := currency.LoadCoins(cfg.CoinListPath)
 	if err != nil {
 		log.Fatalf("failed to load coins: %v", err)
 	}
 
+	chains, err := currency.LoadChains(cfg.ChainListPath)
+	if err != nil {
+		log.Fatalf("failed to load chains: %v", err)
+	}
+	var coinIndex currency.CoinResolver = currency.NewCoinIndex(supported_coins, chains)
+	if cfg.CoinsAutoRefresh && cfg.Offline {
+		log.Fatalf("coin list auto refresh calls CoinGecko, disable SEQUENCE_COINS_AUTO_REFRESH in offline mode")
+	}
+	if cfg.CoinsAutoRefresh {
+		coinGeckoClient, err := coingecko.NewClient(cfg)
+		if err != nil {
+			log.Fatalf("failed to create CoinGecko client: %v", err)
+		}
+		coinIndex = currency.NewRefreshingCoinIndex(supported_coins, chains, func() ([]currency.Coin, error) {
+			coins, diff, err := currency.RefreshCoinList(ctx, coinGeckoClient, cfg.CoinListPath)
+			if err == nil {
+				log.Printf("Coin list refreshed: %s", diff)
+			}
+			return coins, err
+		})
+	}
+
+	decimalsRegistry, err := currency.LoadDecimals(cfg.DecimalsPath)
+	if err != nil {
+		log.Fatalf("failed to load token decimals: %v", err)
+	}
+
+	rateProvider, err := currency.NewRateProvider(cfg)
+	if err != nil {
+		log.Fatalf("failed to initialize rate providers: %v", err)
+	}
+	if cfg.RatesSnapshotExport && (cfg.Offline || cfg.RatesSnapshotPath == "") {
+		log.Fatalf("rate snapshot export needs an online run and SEQUENCE_RATES_SNAPSHOT_PATH")
+	}
+
+	rateMatching, err := etl.NewRateMatching(cfg.RateStrategy, cfg.RateMaxDistance)
+	if err != nil {
+		log.Fatalf("failed to configure rate matching: %v", err)
+	}
+
+	pegPolicy, err := etl.NewPegPolicy(cfg.RatePegs, cfg.PegMode, cfg.PegTolerance)
+	if err != nil {
+		log.Fatalf("failed to configure pegs: %v", err)
+	}
+
+	targetCurrencies, err := etl.ParseTargetCurrencies(cfg.TargetCurrencies, cfg.DefaultCurrency)
+	if err != nil {
+		log.Fatalf("failed to configure target currencies: %v", err)
+	}
+
 	// ------------------------ PROCESS DATA -----------------------------------
 	startTime := time.Now()
 
 	// Extract events and collect currency usage
-	events, currencyUsageMap := etl.ExtractEvents(reader, supported_coins)
-
-	// Get exchange rates
-	exchangeRates, err := etl.GetExchangeRates(currencyUsageMap, cfg.DefaultCurrency, currency.FetchExchangeRates)
-
+	events, currencyUsageMap, err := etl.ExtractEvents(storageClient, objectNames, cfg.InputFormat, coinIndex, decimalsRegistry, deadLetters)
 	if err != nil {
-		log.Fatalf("failed to get exchange rates: %v", err)
+		log.Fatalf("failed to extract events: %v", err)
+	}
+	if err := deadLetters.Close(); err != nil {
+		log.Fatalf("failed to write dead letters: %v", err)
 	}
 
-	// Update exchange rates in each event
-	etl.UpdateExchangeRates(events, exchangeRates)
+	// Price and aggregate the events once per target currency
+	fetchRanges := rateMatching.FetchRanges(currencyUsageMap)
+	var aggregatedEvents []etl.AggregatePerProject
+	var rateRecords []etl.ExchangeRate
+	var eventRecords []etl.EventRecord
+	var snapshotSeries []currency.RateSnapshotSeries
+	pricingReports := make(map[string]*etl.PricingReport, len(targetCurrencies))
+	var pegDeviations etl.PegDeviations
+
+	for _, targetCurrency := range targetCurrencies {
+		// Get exchange rates
+		exchangeRates, deviations, err := etl.GetExchangeRates(ctx, fetchRanges, targetCurrency, rateProvider, pegPolicy, rateMatching.NeedsVolumes())
+		if err != nil {
+			log.Fatalf("failed to get %s exchange rates: %v", targetCurrency, err)
+		}
+		pegDeviations = append(pegDeviations, deviations...)
+		rateRecords = append(rateRecords, exchangeRates.Records(targetCurrency)...)
+		if cfg.RatesSnapshotExport {
+			snapshotSeries = append(snapshotSeries, exchangeRates.SnapshotSeries(targetCurrency, fetchRanges)...)
+		}
+
+		// Update exchange rates in each event
+		pricingReports[targetCurrency] = etl.UpdateExchangeRates(events, exchangeRates, rateMatching)
+
+		// Aggregate events using concurrency
+		aggregatedEvents = append(aggregatedEvents, etl.AggregateEvents(events, targetCurrency)...)
+
+		// Events are priced again in the next currency, so their records are taken now
+		if cfg.EventsTable {
+			eventRecords = append(eventRecords, etl.EventRecords(events, targetCurrency)...)
+		}
+	}
 
-	// Aggregate events using concurrency
-	aggregatedEvents := etl.AggregateEvents(events, cfg.DefaultCurrency)
+	// Keeps the rates of every provider the run priced with for offline reruns
+	if cfg.RatesSnapshotExport {
+		if err := currency.NewRateSnapshot(snapshotSeries, time.Now()).Write(cfg.RatesSnapshotPath); err != nil {
+			log.Fatalf("failed to write rate snapshot: %v", err)
+		}
+		log.Printf("Exported rates of %d coin and currency pairs to %s", len(snapshotSeries), cfg.RatesSnapshotPath)
+	}
 
 	// Upserts aggregated events into BigQuery
-	if err := dbClient.Upsert(ctx, "aggregation", aggregatedEvents); err != nil {
+	if err := dbClient.UpsertAggregations(ctx, aggregatedEvents); err != nil {
 		log.Fatalf("failed to merge records into BigQuery: %v", err)
 	}
 
+	// Stores the rates the aggregations were computed from
+	if err := dbClient.UpsertRates(ctx, rateRecords); err != nil {
+		log.Fatalf("failed to merge exchange rates into BigQuery: %v", err)
+	}
+
+	if cfg.EventsTable {
+		if err := dbClient.ReplaceEvents(ctx, eventRecords); err != nil {
+			log.Fatalf("failed to load events into BigQuery: %v", err)
+		}
+	}
+
 	duration := time.Since(startTime).Seconds()
 	log.Printf("Processed %d events in %v sec", len(events), duration)
+	deadLetters.LogSummary()
+	for _, targetCurrency := range targetCurrencies {
+		log.Printf("Pricing in %s:", targetCurrency)
+		pricingReports[targetCurrency].LogSummary()
+	}
+	pegDeviations.LogSummary()
 }
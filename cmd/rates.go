@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"time"
+
+	"bdaggregator/internal/config"
+	"bdaggregator/internal/currency"
+)
+
+const ratesUsage = `usage:
+  bdagg rates export [-out <path>] [-coin <coin-id>] [-currency <currency>]`
+
+// Exports the rates cached by online runs to a snapshot for SEQUENCE_OFFLINE runs
+func runRatesCommand(cfg *config.Config, args []string) {
+	if len(args) == 0 || args[0] != "export" {
+		log.Fatal(ratesUsage)
+	}
+	if cfg.RateCacheDir == "" {
+		log.Fatalf("rate cache is disabled, set SEQUENCE_RATE_CACHE_DIR")
+	}
+
+	flags := flag.NewFlagSet("rates export", flag.ExitOnError)
+	out := flags.String("out", cfg.RatesSnapshotPath, "snapshot file to write, defaults to SEQUENCE_RATES_SNAPSHOT_PATH")
+	coinID := flags.String("coin", "", "export only this coin ID")
+	targetCurrency := flags.String("currency", "", "export only this target currency")
+	flags.Parse(args[1:])
+
+	if *out == "" {
+		log.Fatal(ratesUsage)
+	}
+
+	snapshot, err := currency.NewRateCache(cfg.RateCacheDir).Snapshot(*coinID, *targetCurrency, time.Now())
+	if err != nil {
+		log.Fatalf("failed to read rate cache: %v", err)
+	}
+	if len(snapshot.Series) == 0 {
+		log.Fatalf("no cached rates to export in %s", cfg.RateCacheDir)
+	}
+	if err := snapshot.Write(*out); err != nil {
+		log.Fatalf("failed to write rate snapshot: %v", err)
+	}
+	log.Printf("Exported rates of %d coin and currency pairs to %s", len(snapshot.Series), *out)
+}
@@ -9,15 +9,51 @@ type Config struct {
 	BigQueryProject       string
 	BigQueryDataset       string
 	BigQueryLocation      string
+	BigQueryNumericType   string
+	VolumeScale           string
+	VolumeRounding        string
+	EventsTable           bool
 	GoogleCloudStorageURL string
 	GCSBucket             string
 	GCSObject             string
+	S3Endpoint            string
+	S3Region              string
+	S3Bucket              string
+	S3Object              string
+	S3AccessKeyID         string
+	S3SecretAccessKey     string
+	S3UsePathStyle        bool
+	S3Anonymous           bool
 	LocalStoragePath      string
 	StorageType           string
+	InputFormat           string
+	DeadLetterType        string
+	DeadLetterPath        string
+	RateProviders         string
+	RatesFilePath         string
+	RatePegs              string
+	PegMode               string
+	PegTolerance          string
+	RateCacheDir          string
+	RatesSnapshotPath     string
+	RatesSnapshotExport   bool
+	Offline               bool
+	RateStrategy          string
+	RateMaxDistance       string
+	CoinGeckoPlan         string
 	CoinGeckoAPIKey       string
 	CoinGeckoAPIURL       string
+	CoinGeckoTimeout      string
+	CoinGeckoMaxRetries   string
+	CoinGeckoRateLimit    string
+	CoinGeckoGranularity  string
+	CoinGeckoWorkers      string
 	CoinListPath          string
+	CoinsAutoRefresh      bool
+	ChainListPath         string
+	DecimalsPath          string
 	DefaultCurrency       string
+	TargetCurrencies      string
 }
 
 // SEQUENCE_ prefix allows to grepping the env variables and
@@ -28,14 +64,50 @@ func LoadConfig() *Config {
 		BigQueryProject:       os.Getenv("SEQUENCE_BIGQUERY_PROJECT"),
 		BigQueryDataset:       os.Getenv("SEQUENCE_BIGQUERY_DATASET"),
 		BigQueryLocation:      os.Getenv("SEQUENCE_BIGQUERY_LOCATION"),
+		BigQueryNumericType:   os.Getenv("SEQUENCE_BIGQUERY_NUMERIC_TYPE"),
+		VolumeScale:           os.Getenv("SEQUENCE_VOLUME_SCALE"),
+		VolumeRounding:        os.Getenv("SEQUENCE_VOLUME_ROUNDING"),
+		EventsTable:           os.Getenv("SEQUENCE_EVENTS_TABLE") == "true",
 		GoogleCloudStorageURL: os.Getenv("SEQUENCE_GOOGLE_CLOUD_STORAGE_URL"),
 		GCSBucket:             os.Getenv("SEQUENCE_GCS_BUCKET"),
 		GCSObject:             os.Getenv("SEQUENCE_GCS_OBJECT"),
+		S3Endpoint:            os.Getenv("SEQUENCE_S3_ENDPOINT"),
+		S3Region:              os.Getenv("SEQUENCE_S3_REGION"),
+		S3Bucket:              os.Getenv("SEQUENCE_S3_BUCKET"),
+		S3Object:              os.Getenv("SEQUENCE_S3_OBJECT"),
+		S3AccessKeyID:         os.Getenv("SEQUENCE_S3_ACCESS_KEY_ID"),
+		S3SecretAccessKey:     os.Getenv("SEQUENCE_S3_SECRET_ACCESS_KEY"),
+		S3UsePathStyle:        os.Getenv("SEQUENCE_S3_USE_PATH_STYLE") == "true",
+		S3Anonymous:           os.Getenv("SEQUENCE_S3_ANONYMOUS") == "true",
 		LocalStoragePath:      os.Getenv("SEQUENCE_LOCAL_STORAGE_PATH"),
 		StorageType:           os.Getenv("SEQUENCE_STORAGE_TYPE"),
+		InputFormat:           os.Getenv("SEQUENCE_INPUT_FORMAT"),
+		DeadLetterType:        os.Getenv("SEQUENCE_DEAD_LETTER_TYPE"),
+		DeadLetterPath:        os.Getenv("SEQUENCE_DEAD_LETTER_PATH"),
+		RateProviders:         os.Getenv("SEQUENCE_RATE_PROVIDERS"),
+		RatesFilePath:         os.Getenv("SEQUENCE_RATES_FILE_PATH"),
+		RatePegs:              os.Getenv("SEQUENCE_RATE_PEGS"),
+		PegMode:               os.Getenv("SEQUENCE_PEG_MODE"),
+		PegTolerance:          os.Getenv("SEQUENCE_PEG_TOLERANCE"),
+		RateCacheDir:          os.Getenv("SEQUENCE_RATE_CACHE_DIR"),
+		RatesSnapshotPath:     os.Getenv("SEQUENCE_RATES_SNAPSHOT_PATH"),
+		RatesSnapshotExport:   os.Getenv("SEQUENCE_RATES_SNAPSHOT_EXPORT") == "true",
+		Offline:               os.Getenv("SEQUENCE_OFFLINE") == "true",
+		RateStrategy:          os.Getenv("SEQUENCE_RATE_STRATEGY"),
+		RateMaxDistance:       os.Getenv("SEQUENCE_RATE_MAX_DISTANCE"),
+		CoinGeckoPlan:         os.Getenv("SEQUENCE_COINGECKO_PLAN"),
 		CoinGeckoAPIKey:       os.Getenv("SEQUENCE_COINGECKO_API_KEY"),
 		CoinGeckoAPIURL:       os.Getenv("SEQUENCE_COINGECKO_API_URL"),
+		CoinGeckoTimeout:      os.Getenv("SEQUENCE_COINGECKO_TIMEOUT"),
+		CoinGeckoMaxRetries:   os.Getenv("SEQUENCE_COINGECKO_MAX_RETRIES"),
+		CoinGeckoRateLimit:    os.Getenv("SEQUENCE_COINGECKO_RATE_LIMIT"),
+		CoinGeckoGranularity:  os.Getenv("SEQUENCE_COINGECKO_GRANULARITY"),
+		CoinGeckoWorkers:      os.Getenv("SEQUENCE_COINGECKO_WORKERS"),
 		CoinListPath:          os.Getenv("SEQUENCE_COINS_FILE_PATH"),
+		CoinsAutoRefresh:      os.Getenv("SEQUENCE_COINS_AUTO_REFRESH") == "true",
+		ChainListPath:         os.Getenv("SEQUENCE_CHAINS_FILE_PATH"),
+		DecimalsPath:          os.Getenv("SEQUENCE_DECIMALS_FILE_PATH"),
 		DefaultCurrency:       os.Getenv("SEQUENCE_DEFAULT_CURRENCY"),
+		TargetCurrencies:      os.Getenv("SEQUENCE_TARGET_CURRENCIES"),
 	}
 }
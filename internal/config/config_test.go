@@ -31,15 +31,49 @@ func TestLoadConfig(t *testing.T) {
 		"SEQUENCE_BIGQUERY_PROJECT":         "test_project",
 		"SEQUENCE_BIGQUERY_DATASET":         "test_dataset",
 		"SEQUENCE_BIGQUERY_LOCATION":        "US",
+		"SEQUENCE_BIGQUERY_NUMERIC_TYPE":    "BIGNUMERIC",
+		"SEQUENCE_VOLUME_SCALE":             "6",
+		"SEQUENCE_VOLUME_ROUNDING":          "half_even",
 		"SEQUENCE_GOOGLE_CLOUD_STORAGE_URL": "https://storage.googleapis.com",
 		"SEQUENCE_GCS_BUCKET":               "test_bucket",
 		"SEQUENCE_GCS_OBJECT":               "test_object",
+		"SEQUENCE_S3_ENDPOINT":              "http://localhost:9000",
+		"SEQUENCE_S3_REGION":                "eu-central-1",
+		"SEQUENCE_S3_BUCKET":                "test_s3_bucket",
+		"SEQUENCE_S3_OBJECT":                "test_s3_object",
+		"SEQUENCE_S3_ACCESS_KEY_ID":         "test_access_key",
+		"SEQUENCE_S3_SECRET_ACCESS_KEY":     "test_secret_key",
+		"SEQUENCE_S3_USE_PATH_STYLE":        "true",
 		"SEQUENCE_LOCAL_STORAGE_PATH":       "/tmp",
 		"SEQUENCE_STORAGE_TYPE":             "GCS",
+		"SEQUENCE_INPUT_FORMAT":             "ndjson",
+		"SEQUENCE_DEAD_LETTER_TYPE":         "storage",
+		"SEQUENCE_DEAD_LETTER_PATH":         "rejected/dead_letters.ndjson",
+		"SEQUENCE_RATE_PROVIDERS":           "file,coingecko,peg",
+		"SEQUENCE_RATES_FILE_PATH":          "/path/to/rates.csv",
+		"SEQUENCE_RATE_PEGS":                "usd-coin:usd=1",
+		"SEQUENCE_PEG_MODE":                 "check",
+		"SEQUENCE_PEG_TOLERANCE":            "0.005",
+		"SEQUENCE_RATE_CACHE_DIR":           "/tmp/rates",
+		"SEQUENCE_RATES_SNAPSHOT_PATH":      "/path/to/snapshot.json",
+		"SEQUENCE_RATES_SNAPSHOT_EXPORT":    "true",
+		"SEQUENCE_OFFLINE":                  "true",
+		"SEQUENCE_RATE_STRATEGY":            "linear",
+		"SEQUENCE_RATE_MAX_DISTANCE":        "2h",
+		"SEQUENCE_COINGECKO_PLAN":           "pro",
 		"SEQUENCE_COINGECKO_API_KEY":        "test_api_key",
 		"SEQUENCE_COINGECKO_API_URL":        "https://api.coingecko.com",
+		"SEQUENCE_COINGECKO_TIMEOUT":        "10s",
+		"SEQUENCE_COINGECKO_MAX_RETRIES":    "5",
+		"SEQUENCE_COINGECKO_RATE_LIMIT":     "500",
+		"SEQUENCE_COINGECKO_GRANULARITY":    "daily",
+		"SEQUENCE_COINGECKO_WORKERS":        "8",
 		"SEQUENCE_COINS_FILE_PATH":          "/path/to/coins.json",
+		"SEQUENCE_COINS_AUTO_REFRESH":       "true",
+		"SEQUENCE_CHAINS_FILE_PATH":         "/path/to/chains.json",
+		"SEQUENCE_DECIMALS_FILE_PATH":       "/path/to/decimals.json",
 		"SEQUENCE_DEFAULT_CURRENCY":         "USD",
+		"SEQUENCE_TARGET_CURRENCIES":        "usd,eur",
 	}
 
 	// Set environment variables and defer the restoration of the original values
@@ -51,13 +85,47 @@ func TestLoadConfig(t *testing.T) {
 	assert.Equal(t, "test_project", cfg.BigQueryProject)
 	assert.Equal(t, "test_dataset", cfg.BigQueryDataset)
 	assert.Equal(t, "US", cfg.BigQueryLocation)
+	assert.Equal(t, "BIGNUMERIC", cfg.BigQueryNumericType)
+	assert.Equal(t, "6", cfg.VolumeScale)
+	assert.Equal(t, "half_even", cfg.VolumeRounding)
 	assert.Equal(t, "https://storage.googleapis.com", cfg.GoogleCloudStorageURL)
 	assert.Equal(t, "test_bucket", cfg.GCSBucket)
 	assert.Equal(t, "test_object", cfg.GCSObject)
+	assert.Equal(t, "http://localhost:9000", cfg.S3Endpoint)
+	assert.Equal(t, "eu-central-1", cfg.S3Region)
+	assert.Equal(t, "test_s3_bucket", cfg.S3Bucket)
+	assert.Equal(t, "test_s3_object", cfg.S3Object)
+	assert.Equal(t, "test_access_key", cfg.S3AccessKeyID)
+	assert.Equal(t, "test_secret_key", cfg.S3SecretAccessKey)
+	assert.True(t, cfg.S3UsePathStyle)
 	assert.Equal(t, "/tmp", cfg.LocalStoragePath)
 	assert.Equal(t, "GCS", cfg.StorageType)
+	assert.Equal(t, "ndjson", cfg.InputFormat)
+	assert.Equal(t, "storage", cfg.DeadLetterType)
+	assert.Equal(t, "rejected/dead_letters.ndjson", cfg.DeadLetterPath)
+	assert.Equal(t, "file,coingecko,peg", cfg.RateProviders)
+	assert.Equal(t, "/path/to/rates.csv", cfg.RatesFilePath)
+	assert.Equal(t, "usd-coin:usd=1", cfg.RatePegs)
+	assert.Equal(t, "check", cfg.PegMode)
+	assert.Equal(t, "0.005", cfg.PegTolerance)
+	assert.Equal(t, "/tmp/rates", cfg.RateCacheDir)
+	assert.Equal(t, "/path/to/snapshot.json", cfg.RatesSnapshotPath)
+	assert.True(t, cfg.RatesSnapshotExport)
+	assert.True(t, cfg.Offline)
+	assert.Equal(t, "linear", cfg.RateStrategy)
+	assert.Equal(t, "2h", cfg.RateMaxDistance)
+	assert.Equal(t, "pro", cfg.CoinGeckoPlan)
 	assert.Equal(t, "test_api_key", cfg.CoinGeckoAPIKey)
 	assert.Equal(t, "https://api.coingecko.com", cfg.CoinGeckoAPIURL)
+	assert.Equal(t, "10s", cfg.CoinGeckoTimeout)
+	assert.Equal(t, "5", cfg.CoinGeckoMaxRetries)
+	assert.Equal(t, "500", cfg.CoinGeckoRateLimit)
+	assert.Equal(t, "daily", cfg.CoinGeckoGranularity)
+	assert.Equal(t, "8", cfg.CoinGeckoWorkers)
 	assert.Equal(t, "/path/to/coins.json", cfg.CoinListPath)
+	assert.True(t, cfg.CoinsAutoRefresh)
+	assert.Equal(t, "/path/to/chains.json", cfg.ChainListPath)
+	assert.Equal(t, "/path/to/decimals.json", cfg.DecimalsPath)
 	assert.Equal(t, "USD", cfg.DefaultCurrency)
+	assert.Equal(t, "usd,eur", cfg.TargetCurrencies)
 }
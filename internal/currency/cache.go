@@ -0,0 +1,337 @@
+package currency
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Rates are cached in daily buckets, a bucket is reused only when it was fetched completely
+// and at least as finely as the provider serves rates now
+const rateCacheBucket int64 = 24 * 60 * 60
+
+// RateCache stores fetched rates on disk as <dir>/<coin>/<currency>/<day>.json
+type RateCache struct {
+	dir string
+}
+
+type rateCacheFile struct {
+	FetchedAt  int64                     `json:"fetchedAt"`
+	Resolution int64                     `json:"resolution"` // seconds between the fetched rates, 0 when unknown
+	Rates      map[int64]decimal.Decimal `json:"rates"`
+	Volumes    map[int64]decimal.Decimal `json:"volumes"` // null when fetched without volumes
+}
+
+// RateCacheEntry summarizes the cached rates of a coin in a target currency
+type RateCacheEntry struct {
+	CoinID   string
+	Currency string
+	Buckets  int
+	Rates    int
+	From     time.Time
+	To       time.Time
+	Size     int64
+}
+
+func NewRateCache(dir string) *RateCache {
+	return &RateCache{dir: dir}
+}
+
+func (c *RateCache) bucketPath(coinID, targetCurrency string, bucket int64) string {
+	day := time.Unix(bucket, 0).UTC().Format("2006-01-02")
+	return filepath.Join(c.dir, coinID, strings.ToLower(targetCurrency), day+".json")
+}
+
+func (c *RateCache) load(coinID, targetCurrency string, bucket int64) (rateCacheFile, bool, error) {
+	data, err := os.ReadFile(c.bucketPath(coinID, targetCurrency, bucket))
+	if errors.Is(err, os.ErrNotExist) {
+		return rateCacheFile{}, false, nil
+	}
+	if err != nil {
+		return rateCacheFile{}, false, err
+	}
+
+	var file rateCacheFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return rateCacheFile{}, false, fmt.Errorf("corrupted cache file: %w", err)
+	}
+	return file, true, nil
+}
+
+// Writes the bucket to a temporary file first, so an interrupted run never leaves a partial bucket
+func (c *RateCache) store(coinID, targetCurrency string, bucket int64, rates, volumes map[int64]decimal.Decimal, resolution int64, fetchedAt time.Time) error {
+	path := c.bucketPath(coinID, targetCurrency, bucket)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(rateCacheFile{FetchedAt: fetchedAt.Unix(), Resolution: resolution, Rates: rates, Volumes: volumes})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data, ".bucket-*")
+}
+
+// Lists the cached coins and target currencies with the days they cover
+func (c *RateCache) Inspect() ([]RateCacheEntry, error) {
+	entries := make(map[string]*RateCacheEntry)
+
+	err := c.walk(func(coinID, targetCurrency string, day time.Time, path string, info os.FileInfo) error {
+		key := rateSeriesKey(coinID, targetCurrency)
+		entry, exists := entries[key]
+		if !exists {
+			entry = &RateCacheEntry{CoinID: coinID, Currency: targetCurrency, From: day, To: day}
+			entries[key] = entry
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var file rateCacheFile
+		if err := json.Unmarshal(data, &file); err != nil {
+			return fmt.Errorf("corrupted cache file %s: %w", path, err)
+		}
+
+		entry.Buckets++
+		entry.Rates += len(file.Rates)
+		entry.Size += info.Size()
+		if day.Before(entry.From) {
+			entry.From = day
+		}
+		if day.After(entry.To) {
+			entry.To = day
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]RateCacheEntry, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, *entry)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].CoinID != result[j].CoinID {
+			return result[i].CoinID < result[j].CoinID
+		}
+		return result[i].Currency < result[j].Currency
+	})
+	return result, nil
+}
+
+// Removes cached buckets, empty filters match everything and a zero time matches every day.
+// Returns the number of removed buckets.
+func (c *RateCache) Purge(coinID, targetCurrency string, before time.Time) (int, error) {
+	removed := 0
+	err := c.walk(func(coin, currency string, day time.Time, path string, info os.FileInfo) error {
+		if coinID != "" && coin != coinID {
+			return nil
+		}
+		if targetCurrency != "" && currency != strings.ToLower(targetCurrency) {
+			return nil
+		}
+		if !before.IsZero() && !day.Before(before) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		removed++
+		return nil
+	})
+	return removed, err
+}
+
+func (c *RateCache) walk(fn func(coinID, targetCurrency string, day time.Time, path string, info os.FileInfo) error) error {
+	err := filepath.Walk(c.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(c.dir, path)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) != 3 {
+			return nil
+		}
+		day, err := time.Parse("2006-01-02", strings.TrimSuffix(parts[2], ".json"))
+		if err != nil {
+			return nil
+		}
+		return fn(parts[0], parts[1], day, path, info)
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// CachedProvider serves rates from the cache and fetches only the days missing in it.
+// Days cached from a coarser fetch than the provider's resolution count as missing.
+// The volumes of a provider serving them are cached with the rates.
+type CachedProvider struct {
+	provider   RateProvider
+	cache      *RateCache
+	resolution int64 // expected seconds between the provider's rates
+	now        func() time.Time
+}
+
+func NewCachedProvider(provider RateProvider, cache *RateCache, resolution int64) *CachedProvider {
+	return &CachedProvider{provider: provider, cache: cache, resolution: resolution, now: time.Now}
+}
+
+// Rates are rarely exactly evenly spaced, a bucket is coarser only beyond twice the resolution
+func (p *CachedProvider) usable(file rateCacheFile) bool {
+	return file.Resolution > 0 && file.Resolution <= 2*p.resolution
+}
+
+func (p *CachedProvider) Name() string {
+	return p.provider.Name()
+}
+
+func (p *CachedProvider) FetchRates(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error) {
+	rates, _, err := p.fetch(ctx, coinID, targetCurrency, from, to, false)
+	return rates, err
+}
+
+// Days cached without volumes count as missing, the volumes are nil if the provider has none
+func (p *CachedProvider) FetchRatesWithVolumes(ctx context.Context, coinID, targetCurrency string, from, to int64) (rates, volumes map[int64]decimal.Decimal, err error) {
+	return p.fetch(ctx, coinID, targetCurrency, from, to, true)
+}
+
+func (p *CachedProvider) fetch(ctx context.Context, coinID, targetCurrency string, from, to int64, withVolumes bool) (map[int64]decimal.Decimal, map[int64]decimal.Decimal, error) {
+	_, servesVolumes := p.provider.(VolumeProvider)
+	withVolumes = withVolumes && servesVolumes
+
+	rates := make(map[int64]decimal.Decimal)
+	var volumes map[int64]decimal.Decimal
+	if withVolumes {
+		volumes = make(map[int64]decimal.Decimal)
+	}
+	var missing []int64
+
+	for bucket := bucketStart(from); bucket <= to; bucket += rateCacheBucket {
+		cached, exists, err := p.cache.load(coinID, targetCurrency, bucket)
+		if err != nil {
+			log.Printf("Ignoring rate cache for %s on %s: %v", coinID, time.Unix(bucket, 0).UTC().Format("2006-01-02"), err)
+		}
+		if !exists || err != nil || !p.usable(cached) || withVolumes && cached.Volumes == nil {
+			missing = append(missing, bucket)
+			continue
+		}
+		for timestamp, rate := range cached.Rates {
+			rates[timestamp] = rate
+		}
+		if withVolumes {
+			maps.Copy(volumes, cached.Volumes)
+		}
+	}
+
+	for _, gap := range contiguousBuckets(missing) {
+		gapFrom, gapTo := gap[0], gap[len(gap)-1]+rateCacheBucket-1
+		// Volumes come with the rates, they are fetched and cached whenever the provider serves them
+		fetched, fetchedVolumes, err := FetchRatesWithVolumes(ctx, p.provider, coinID, targetCurrency, gapFrom, gapTo)
+		if err != nil {
+			return nil, nil, err
+		}
+		for timestamp, rate := range fetched {
+			rates[timestamp] = rate
+		}
+		if withVolumes {
+			maps.Copy(volumes, fetchedVolumes)
+		}
+		p.storeGap(coinID, targetCurrency, gap, fetched, fetchedVolumes, fetchResolution(fetched, gapFrom, gapTo))
+	}
+
+	if len(missing) > 0 {
+		log.Printf("Rate cache for %s: %d of %d days fetched", coinID, len(missing), (bucketStart(to)-bucketStart(from))/rateCacheBucket+1)
+	}
+	return rates, volumes, nil
+}
+
+// Caches the fetched buckets that are already over, today's rates are still changing.
+// Buckets without rates are not cached, the source may not have had them yet or the
+// request may have failed, so they are fetched again by the next run. A bucket's volumes
+// stay nil when the fetch had none, and are empty when the source reported none that day.
+func (p *CachedProvider) storeGap(coinID, targetCurrency string, gap []int64, fetched, fetchedVolumes map[int64]decimal.Decimal, resolution int64) {
+	buckets := make(map[int64]map[int64]decimal.Decimal)
+	bucketVolumes := make(map[int64]map[int64]decimal.Decimal)
+	for timestamp, rate := range fetched {
+		bucket := bucketStart(timestamp)
+		if buckets[bucket] == nil {
+			buckets[bucket] = make(map[int64]decimal.Decimal)
+			if fetchedVolumes != nil {
+				bucketVolumes[bucket] = make(map[int64]decimal.Decimal)
+			}
+		}
+		buckets[bucket][timestamp] = rate
+		if volume, exists := fetchedVolumes[timestamp]; exists {
+			bucketVolumes[bucket][timestamp] = volume
+		}
+	}
+
+	now := p.now()
+	for _, bucket := range gap {
+		bucketRates := buckets[bucket]
+		if len(bucketRates) == 0 || bucket+rateCacheBucket > now.Unix() {
+			continue
+		}
+		if err := p.cache.store(coinID, targetCurrency, bucket, bucketRates, bucketVolumes[bucket], resolution, now); err != nil {
+			log.Printf("Failed to cache rates for %s: %v", coinID, err)
+		}
+	}
+}
+
+// Median spacing of the fetched rates, robust to a missing rate or a last rate taken
+// at the time of the request. Fewer than two rates count as spanning the whole range.
+func fetchResolution(rates map[int64]decimal.Decimal, from, to int64) int64 {
+	timestamps := make([]int64, 0, len(rates))
+	for timestamp := range rates {
+		timestamps = append(timestamps, timestamp)
+	}
+	if len(timestamps) < 2 {
+		return to - from + 1
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+
+	spacings := make([]int64, 0, len(timestamps)-1)
+	for i := 1; i < len(timestamps); i++ {
+		spacings = append(spacings, timestamps[i]-timestamps[i-1])
+	}
+	sort.Slice(spacings, func(i, j int) bool { return spacings[i] < spacings[j] })
+	return spacings[len(spacings)/2]
+}
+
+func bucketStart(timestamp int64) int64 {
+	return timestamp - ((timestamp%rateCacheBucket)+rateCacheBucket)%rateCacheBucket
+}
+
+// Groups sorted bucket starts into runs of consecutive days
+func contiguousBuckets(buckets []int64) [][]int64 {
+	var gaps [][]int64
+	for i, bucket := range buckets {
+		if i > 0 && bucket == buckets[i-1]+rateCacheBucket {
+			gaps[len(gaps)-1] = append(gaps[len(gaps)-1], bucket)
+			continue
+		}
+		gaps = append(gaps, []int64{bucket})
+	}
+	return gaps
+}
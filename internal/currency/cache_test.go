@@ -0,0 +1,287 @@
+package currency
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// Records the requested ranges and returns an hourly rate for each of them
+type rangeRecorder struct {
+	ranges [][2]int64
+}
+
+func (p *rangeRecorder) Name() string {
+	return "recorder"
+}
+
+func (p *rangeRecorder) FetchRates(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error) {
+	p.ranges = append(p.ranges, [2]int64{from, to})
+	rates := make(map[int64]decimal.Decimal)
+	for ts := from; ts <= to; ts += 3600 {
+		rates[ts] = decimal.NewFromInt(ts / 3600)
+	}
+	return rates, nil
+}
+
+func newTestCachedProvider(t *testing.T, provider RateProvider, now time.Time) (*CachedProvider, *RateCache) {
+	cache := NewRateCache(t.TempDir())
+	cached := NewCachedProvider(provider, cache, 3600)
+	cached.now = func() time.Time { return now }
+	return cached, cache
+}
+
+func TestCachedProvider_FetchesOnlyGaps(t *testing.T) {
+	day := func(d int) int64 { return time.Date(2024, 4, d, 0, 0, 0, 0, time.UTC).Unix() }
+	recorder := &rangeRecorder{}
+	provider, _ := newTestCachedProvider(t, recorder, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+
+	// The first run fetches April 2-3 as whole days
+	rates, err := provider.FetchRates(context.Background(), "bitcoin", "usd", day(2)+3600, day(3)+7200)
+	assert.NoError(t, err)
+	assert.Len(t, rates, 48)
+	assert.Equal(t, [][2]int64{{day(2), day(4) - 1}}, recorder.ranges)
+
+	// The second run fetches only the uncovered April 1 and April 4-5
+	recorder.ranges = nil
+	rates, err = provider.FetchRates(context.Background(), "bitcoin", "usd", day(1), day(5)+60)
+	assert.NoError(t, err)
+	assert.Len(t, rates, 5*24)
+	assert.Equal(t, [][2]int64{{day(1), day(2) - 1}, {day(4), day(6) - 1}}, recorder.ranges)
+
+	// Everything is cached now
+	recorder.ranges = nil
+	_, err = provider.FetchRates(context.Background(), "bitcoin", "usd", day(1), day(5))
+	assert.NoError(t, err)
+	assert.Empty(t, recorder.ranges)
+
+	// Another currency has its own cache
+	_, err = provider.FetchRates(context.Background(), "bitcoin", "eur", day(1), day(1))
+	assert.NoError(t, err)
+	assert.Len(t, recorder.ranges, 1)
+}
+
+func TestCachedProvider_SkipsUnfinishedDays(t *testing.T) {
+	now := time.Date(2024, 4, 2, 12, 0, 0, 0, time.UTC)
+	recorder := &rangeRecorder{}
+	provider, cache := newTestCachedProvider(t, recorder, now)
+
+	from := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC).Unix()
+	_, err := provider.FetchRates(context.Background(), "bitcoin", "usd", from, now.Unix())
+	assert.NoError(t, err)
+
+	entries, err := cache.Inspect()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, 1, entries[0].Buckets, "Expected only the finished day to be cached")
+}
+
+func TestCachedProvider_IgnoresCorruptedBuckets(t *testing.T) {
+	recorder := &rangeRecorder{}
+	provider, cache := newTestCachedProvider(t, recorder, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+
+	from := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC).Unix()
+	path := cache.bucketPath("bitcoin", "usd", from)
+	assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	assert.NoError(t, os.WriteFile(path, []byte("{"), 0644))
+
+	rates, err := provider.FetchRates(context.Background(), "bitcoin", "usd", from, from)
+	assert.NoError(t, err)
+	assert.Len(t, rates, 24)
+	assert.Len(t, recorder.ranges, 1)
+}
+
+// Returns a single rate at midnight of each day, like a daily CoinGecko fetch
+type dailyRecorder struct {
+	ranges [][2]int64
+}
+
+func (p *dailyRecorder) Name() string {
+	return "daily"
+}
+
+func (p *dailyRecorder) FetchRates(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error) {
+	p.ranges = append(p.ranges, [2]int64{from, to})
+	rates := make(map[int64]decimal.Decimal)
+	for ts := bucketStart(from); ts <= to; ts += rateCacheBucket {
+		rates[ts] = decimal.NewFromInt(ts / rateCacheBucket)
+	}
+	return rates, nil
+}
+
+func TestCachedProvider_CoarseBucketsAreMisses(t *testing.T) {
+	day := func(d int) int64 { return time.Date(2024, 4, d, 0, 0, 0, 0, time.UTC).Unix() }
+	now := func() time.Time { return time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC) }
+	cache := NewRateCache(t.TempDir())
+
+	daily := NewCachedProvider(&dailyRecorder{}, cache, rateCacheBucket)
+	daily.now = now
+	rates, err := daily.FetchRates(context.Background(), "bitcoin", "usd", day(1), day(3)+60)
+	assert.NoError(t, err)
+	assert.Len(t, rates, 3)
+
+	// An hourly run doesn't reuse the single daily rates
+	recorder := &rangeRecorder{}
+	hourly := NewCachedProvider(recorder, cache, 3600)
+	hourly.now = now
+	rates, err = hourly.FetchRates(context.Background(), "bitcoin", "usd", day(1), day(3)+60)
+	assert.NoError(t, err)
+	assert.Len(t, rates, 3*24)
+	assert.Equal(t, [][2]int64{{day(1), day(4) - 1}}, recorder.ranges)
+
+	// The hourly rates replaced them and also serve a daily run
+	dailyRecorder := &dailyRecorder{}
+	daily = NewCachedProvider(dailyRecorder, cache, rateCacheBucket)
+	daily.now = now
+	_, err = daily.FetchRates(context.Background(), "bitcoin", "usd", day(1), day(3))
+	assert.NoError(t, err)
+	assert.Empty(t, dailyRecorder.ranges)
+}
+
+// Serves rates only from April 3, e.g. a coin listed that day
+type listedRecorder struct {
+	ranges [][2]int64
+	listed int64
+}
+
+func (p *listedRecorder) Name() string {
+	return "listed"
+}
+
+func (p *listedRecorder) FetchRates(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error) {
+	p.ranges = append(p.ranges, [2]int64{from, to})
+	rates := make(map[int64]decimal.Decimal)
+	for ts := max(from, p.listed); ts <= to; ts += 3600 {
+		rates[ts] = decimal.NewFromInt(1)
+	}
+	return rates, nil
+}
+
+func TestCachedProvider_DoesNotCacheDaysWithoutRates(t *testing.T) {
+	day := func(d int) int64 { return time.Date(2024, 4, d, 0, 0, 0, 0, time.UTC).Unix() }
+	recorder := &listedRecorder{listed: day(3)}
+	provider, cache := newTestCachedProvider(t, recorder, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+
+	_, err := provider.FetchRates(context.Background(), "bitcoin", "usd", day(1), day(4)+60)
+	assert.NoError(t, err)
+
+	entries, err := cache.Inspect()
+	assert.NoError(t, err)
+	if assert.Len(t, entries, 1) {
+		assert.Equal(t, 2, entries[0].Buckets, "Expected only the days with rates to be cached")
+	}
+
+	// The days without rates are asked for again
+	recorder.ranges = nil
+	_, err = provider.FetchRates(context.Background(), "bitcoin", "usd", day(1), day(4)+60)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]int64{{day(1), day(3) - 1}}, recorder.ranges)
+}
+
+func TestCachedProvider_BucketsWithoutResolutionAreMisses(t *testing.T) {
+	recorder := &rangeRecorder{}
+	provider, cache := newTestCachedProvider(t, recorder, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+
+	from := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC).Unix()
+	assert.NoError(t, cache.store("bitcoin", "usd", from, map[int64]decimal.Decimal{from: decimal.NewFromInt(1)}, nil, 0, time.Now()))
+
+	rates, err := provider.FetchRates(context.Background(), "bitcoin", "usd", from, from)
+	assert.NoError(t, err)
+	assert.Len(t, rates, 24, "Expected a bucket cached before the resolution was recorded to be fetched again")
+}
+
+func TestFetchResolution(t *testing.T) {
+	rates := map[int64]decimal.Decimal{0: decimal.Zero, 3600: decimal.Zero, 7200: decimal.Zero, 14400: decimal.Zero, 14500: decimal.Zero}
+	assert.Equal(t, int64(3600), fetchResolution(rates, 0, 86399))
+	assert.Equal(t, int64(86400), fetchResolution(map[int64]decimal.Decimal{0: decimal.Zero}, 0, 86399))
+}
+
+func TestRateCache_InspectAndPurge(t *testing.T) {
+	cache := NewRateCache(t.TempDir())
+	fetchedAt := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	for _, coinID := range []string{"bitcoin", "ethereum"} {
+		for d := 1; d <= 3; d++ {
+			bucket := time.Date(2024, 4, d, 0, 0, 0, 0, time.UTC).Unix()
+			rates := map[int64]decimal.Decimal{bucket: decimal.NewFromInt(1), bucket + 3600: decimal.NewFromInt(2)}
+			assert.NoError(t, cache.store(coinID, "usd", bucket, rates, nil, 3600, fetchedAt))
+		}
+	}
+
+	entries, err := cache.Inspect()
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "bitcoin", entries[0].CoinID)
+	assert.Equal(t, "usd", entries[0].Currency)
+	assert.Equal(t, 3, entries[0].Buckets)
+	assert.Equal(t, 6, entries[0].Rates)
+	assert.Equal(t, "2024-04-01", entries[0].From.Format("2006-01-02"))
+	assert.Equal(t, "2024-04-03", entries[0].To.Format("2006-01-02"))
+
+	removed, err := cache.Purge("bitcoin", "", time.Date(2024, 4, 3, 0, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, removed)
+
+	removed, err = cache.Purge("", "", time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, removed)
+
+	entries, err = NewRateCache(filepath.Join(t.TempDir(), "missing")).Inspect()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+// Serves the hourly rates of a rangeRecorder with a volume of 10 each
+type volumeRecorder struct {
+	rangeRecorder
+}
+
+func (p *volumeRecorder) FetchRatesWithVolumes(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, map[int64]decimal.Decimal, error) {
+	rates, err := p.FetchRates(ctx, coinID, targetCurrency, from, to)
+	volumes := make(map[int64]decimal.Decimal, len(rates))
+	for ts := range rates {
+		volumes[ts] = decimal.NewFromInt(10)
+	}
+	return rates, volumes, err
+}
+
+func TestCachedProvider_CachesVolumes(t *testing.T) {
+	day := func(d int) int64 { return time.Date(2024, 4, d, 0, 0, 0, 0, time.UTC).Unix() }
+	now := func() time.Time { return time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC) }
+	cache := NewRateCache(t.TempDir())
+
+	// Rates cached without volumes serve the rates alone
+	withoutVolumes := NewCachedProvider(&rangeRecorder{}, cache, 3600)
+	withoutVolumes.now = now
+	_, err := withoutVolumes.FetchRates(context.Background(), "bitcoin", "usd", day(1), day(2)-1)
+	assert.NoError(t, err)
+
+	recorder := &volumeRecorder{}
+	provider := NewCachedProvider(recorder, cache, 3600)
+	provider.now = now
+	_, err = provider.FetchRates(context.Background(), "bitcoin", "usd", day(1), day(2)-1)
+	assert.NoError(t, err)
+	assert.Empty(t, recorder.ranges)
+
+	// Asking for volumes refetches the day and caches them
+	rates, volumes, err := provider.FetchRatesWithVolumes(context.Background(), "bitcoin", "usd", day(1), day(2)-1)
+	assert.NoError(t, err)
+	assert.Len(t, rates, 24)
+	assert.Len(t, volumes, 24)
+	assert.Equal(t, [][2]int64{{day(1), day(2) - 1}}, recorder.ranges)
+
+	recorder.ranges = nil
+	_, volumes, err = provider.FetchRatesWithVolumes(context.Background(), "bitcoin", "usd", day(1), day(2)-1)
+	assert.NoError(t, err)
+	assert.Empty(t, recorder.ranges)
+	assert.Equal(t, "10", volumes[day(1)].String())
+
+	// A provider without volumes returns none
+	_, volumes, err = withoutVolumes.FetchRatesWithVolumes(context.Background(), "bitcoin", "usd", day(1), day(2)-1)
+	assert.NoError(t, err)
+	assert.Nil(t, volumes)
+}
@@ -0,0 +1,42 @@
+package currency
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// Chain maps an EVM chain ID to its CoinGecko platform key and native gas token
+type Chain struct {
+	ChainID      string `json:"chainId"`
+	Platform     string `json:"platform"`
+	NativeCoinID string `json:"nativeCoinId"`
+	NativeSymbol string `json:"nativeSymbol"`
+}
+
+// Addresses used by marketplaces to denote the native gas token instead of an ERC-20 contract
+var nativeTokenAddresses = map[string]bool{
+	"": true,
+	"0x0000000000000000000000000000000000000000": true,
+	"0xeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee": true,
+}
+
+func isNativeTokenAddress(address string) bool {
+	return nativeTokenAddresses[strings.ToLower(address)]
+}
+
+func LoadChains(filePath string) ([]Chain, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var chains []Chain
+	decoder := json.NewDecoder(file)
+	if err := decoder.Decode(&chains); err != nil {
+		return nil, err
+	}
+
+	return chains, nil
+}
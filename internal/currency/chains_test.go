@@ -0,0 +1,43 @@
+package currency
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadChains(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "chains.json")
+	fileContent := `[{"chainId": "137", "platform": "polygon-pos", "nativeCoinId": "matic-network", "nativeSymbol": "MATIC"}]`
+	assert.NoError(t, os.WriteFile(filePath, []byte(fileContent), 0644))
+
+	chains, err := LoadChains(filePath)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []Chain{{ChainID: "137", Platform: "polygon-pos", NativeCoinID: "matic-network", NativeSymbol: "MATIC"}}, chains)
+}
+
+// The shipped chains.json must parse and only reference known CoinGecko platforms and coins
+func TestLoadChains_RepositoryFile(t *testing.T) {
+	chains, err := LoadChains("../../chains.json")
+	assert.NoError(t, err)
+
+	coins, err := LoadCoins("../../coins.json")
+	assert.NoError(t, err)
+
+	platforms := make(map[string]bool)
+	coinIDs := make(map[string]bool)
+	for _, coin := range coins {
+		coinIDs[coin.ID] = true
+		for platform := range coin.Platforms {
+			platforms[platform] = true
+		}
+	}
+
+	for _, chain := range chains {
+		assert.True(t, platforms[chain.Platform], "unknown platform %s for chain %s", chain.Platform, chain.ChainID)
+		assert.True(t, coinIDs[chain.NativeCoinID], "unknown native coin %s for chain %s", chain.NativeCoinID, chain.ChainID)
+	}
+}
@@ -0,0 +1,167 @@
+package currency
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Returned when a currency matches several coins and cannot be resolved safely
+type AmbiguousCoinError struct {
+	Symbol  string
+	Address string
+	CoinIDs []string
+}
+
+func (e *AmbiguousCoinError) Error() string {
+	if e.Address != "" {
+		return fmt.Sprintf("address '%s' (symbol '%s') matches several coins: %s", e.Address, e.Symbol, strings.Join(e.CoinIDs, ", "))
+	}
+	return fmt.Sprintf("symbol '%s' matches several coins: %s", e.Symbol, strings.Join(e.CoinIDs, ", "))
+}
+
+// CoinResolver maps the currency of an event to a coin ID
+type CoinResolver interface {
+	Resolve(currencySymbol, currencyAddress, chainID string) (string, error)
+}
+
+// Resolves currencies to coin IDs with map lookups instead of scanning the coin list.
+// Built once from LoadCoins and LoadChains and safe for concurrent reads.
+type CoinIndex struct {
+	chains            map[string]Chain    // chain ID -> chain
+	symbols           map[string]string   // coin ID -> lowercase symbol
+	bySymbol          map[string][]string // lowercase symbol -> coin IDs
+	byAddress         map[string][]string // lowercase address -> coin IDs on any platform
+	byPlatformAddress map[string]string   // platform + lowercase address -> coin ID
+}
+
+func NewCoinIndex(coins []Coin, chains []Chain) *CoinIndex {
+	index := &CoinIndex{
+		chains:            make(map[string]Chain, len(chains)),
+		symbols:           make(map[string]string, len(coins)),
+		bySymbol:          make(map[string][]string),
+		byAddress:         make(map[string][]string),
+		byPlatformAddress: make(map[string]string),
+	}
+
+	for _, chain := range chains {
+		index.chains[chain.ChainID] = chain
+	}
+
+	for _, coin := range coins {
+		symbol := strings.ToLower(coin.Symbol)
+		index.symbols[coin.ID] = symbol
+		index.bySymbol[symbol] = appendUnique(index.bySymbol[symbol], coin.ID)
+
+		for platform, address := range coin.Platforms {
+			if address == "" {
+				continue
+			}
+			address = strings.ToLower(address)
+			index.byAddress[address] = appendUnique(index.byAddress[address], coin.ID)
+			index.byPlatformAddress[platformAddressKey(platform, address)] = coin.ID
+		}
+	}
+
+	for _, ids := range index.bySymbol {
+		sort.Strings(ids)
+	}
+	for _, ids := range index.byAddress {
+		sort.Strings(ids)
+	}
+
+	return index
+}
+
+// Find the coin deployed at an address on a CoinGecko platform (e.g. "polygon-pos")
+func (i *CoinIndex) LookupAddress(platform, address string) (string, bool) {
+	coinID, exists := i.byPlatformAddress[platformAddressKey(platform, strings.ToLower(address))]
+	return coinID, exists
+}
+
+// Coin IDs sharing a symbol, more than one means the symbol alone is ambiguous
+func (i *CoinIndex) LookupSymbol(symbol string) []string {
+	return i.bySymbol[strings.ToLower(symbol)]
+}
+
+// Map a currency symbol and address to a coin ID.
+// On a chain listed in chains.json the address must be deployed on the chain's
+// platform, and the native gas token is recognised by the chain's native symbol
+// with an empty or zero address. On other chains the address is looked up on
+// every platform, the symbol only breaking ties between coins sharing it.
+// Rows without an address are resolved by symbol, as long as the symbol
+// belongs to a single coin.
+func (i *CoinIndex) Resolve(currencySymbol, currencyAddress, chainID string) (string, error) {
+	if chain, known := i.chains[chainID]; known {
+		return i.resolveOnChain(chain, currencySymbol, currencyAddress)
+	}
+
+	if currencyAddress == "" {
+		return i.resolveBySymbol(currencySymbol, currencyAddress)
+	}
+
+	candidates := i.byAddress[strings.ToLower(currencyAddress)]
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	if len(candidates) > 1 {
+		matching := i.filterBySymbol(candidates, currencySymbol)
+		if len(matching) == 1 {
+			return matching[0], nil
+		}
+		return "", &AmbiguousCoinError{Symbol: currencySymbol, Address: currencyAddress, CoinIDs: candidates}
+	}
+
+	return "", fmt.Errorf("no matching coin found for symbol '%s' and address '%s'", currencySymbol, currencyAddress)
+}
+
+func (i *CoinIndex) resolveOnChain(chain Chain, currencySymbol, currencyAddress string) (string, error) {
+	if isNativeTokenAddress(currencyAddress) && chain.NativeCoinID != "" && strings.EqualFold(currencySymbol, chain.NativeSymbol) {
+		return chain.NativeCoinID, nil
+	}
+
+	if currencyAddress == "" {
+		return i.resolveBySymbol(currencySymbol, currencyAddress)
+	}
+
+	if coinID, exists := i.LookupAddress(chain.Platform, currencyAddress); exists {
+		return coinID, nil
+	}
+
+	return "", fmt.Errorf("no matching coin found for symbol '%s' and address '%s' on chain %s (%s)", currencySymbol, currencyAddress, chain.ChainID, chain.Platform)
+}
+
+func (i *CoinIndex) resolveBySymbol(currencySymbol, currencyAddress string) (string, error) {
+	candidates := i.LookupSymbol(currencySymbol)
+	if len(candidates) == 1 {
+		return candidates[0], nil
+	}
+	if len(candidates) > 1 {
+		return "", &AmbiguousCoinError{Symbol: currencySymbol, CoinIDs: candidates}
+	}
+
+	return "", fmt.Errorf("no matching coin found for symbol '%s' and address '%s'", currencySymbol, currencyAddress)
+}
+
+func (i *CoinIndex) filterBySymbol(coinIDs []string, symbol string) []string {
+	var matching []string
+	for _, coinID := range coinIDs {
+		if i.symbols[coinID] == strings.ToLower(symbol) {
+			matching = append(matching, coinID)
+		}
+	}
+	return matching
+}
+
+func platformAddressKey(platform, address string) string {
+	return platform + "/" + address
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,117 @@
+package currency
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test for CoinIndex.Resolve
+func TestCoinIndex_Resolve(t *testing.T) {
+	coins := []Coin{
+		{
+			ID:     "bitcoin",
+			Symbol: "BTC",
+			Name:   "Bitcoin",
+			Platforms: map[string]string{
+				"platform1": "address1",
+			},
+		},
+		{
+			ID:     "matic-network",
+			Symbol: "MATIC",
+			Name:   "Polygon",
+			Platforms: map[string]string{
+				"ethereum": "address2",
+			},
+		},
+		{ID: "avalanche-2", Symbol: "AVAX", Platforms: map[string]string{}},
+		{
+			ID:        "bridged-usdc-polygon-pos-bridge",
+			Symbol:    "usdc.e",
+			Platforms: map[string]string{"polygon-pos": "0x2791BCA1F2DE4661ED88A30C99A7A9449AA84174"},
+		},
+		{ID: "usd-coin", Symbol: "usdc", Platforms: map[string]string{"polygon-pos": "0x3c499c", "avalanche": "0xb97ef9"}},
+		{ID: "sunflower-land", Symbol: "sfl", Platforms: map[string]string{"polygon-pos": "0xd1f9", "ethereum": "shared"}},
+		{ID: "sfl-clone", Symbol: "sfl", Platforms: map[string]string{"ethereum": "0xaaaa"}},
+		{ID: "other-token", Symbol: "oth", Platforms: map[string]string{"polygon-pos": "shared"}},
+		{ID: "solo", Symbol: "SOLO", Platforms: map[string]string{}},
+	}
+	chains := []Chain{
+		{ChainID: "1", Platform: "ethereum", NativeCoinID: "ethereum", NativeSymbol: "ETH"},
+		{ChainID: "137", Platform: "polygon-pos", NativeCoinID: "matic-network", NativeSymbol: "MATIC"},
+		{ChainID: "43114", Platform: "avalanche", NativeCoinID: "avalanche-2", NativeSymbol: "AVAX"},
+	}
+	index := NewCoinIndex(coins, chains)
+
+	tests := []struct {
+		currencySymbol   string
+		currencyAddress  string
+		chainID          string
+		expectedCoinID   string
+		expectedErrorMsg string
+	}{
+		{"BTC", "address1", "", "bitcoin", ""},
+		{"MATIC", "address2", "1", "matic-network", ""},
+		{"MATIC", "0x0000000000000000000000000000000000000000", "137", "matic-network", ""},
+		{"AVAX", "", "43114", "avalanche-2", ""},
+		{"USDC", "0x0000000000000000000000000000000000000000", "137", "", "no matching coin found for symbol 'USDC' and address '0x0000000000000000000000000000000000000000' on chain 137 (polygon-pos)"},
+		{"USDC", "0x2791bca1f2de4661ed88a30c99a7a9449aa84174", "137", "bridged-usdc-polygon-pos-bridge", ""},
+		{"USDC", "0xB97EF9", "43114", "usd-coin", ""},
+		{"USDC", "0xb97ef9", "137", "", "no matching coin found for symbol 'USDC' and address '0xb97ef9' on chain 137 (polygon-pos)"},
+		{"SFL", "shared", "1", "sunflower-land", ""},
+		{"OTH", "shared", "137", "other-token", ""},
+		{"SFL", "shared", "", "sunflower-land", ""},
+		{"XYZ", "shared", "", "", "address 'shared' (symbol 'XYZ') matches several coins: other-token, sunflower-land"},
+		{"SOLO", "", "", "solo", ""},
+		{"SOLO", "", "137", "solo", ""},
+		{"SFL", "", "", "", "symbol 'SFL' matches several coins: sfl-clone, sunflower-land"},
+		{"SOLO", "unknown", "", "", "no matching coin found for symbol 'SOLO' and address 'unknown'"},
+		{"ETH", "address3", "", "", "no matching coin found for symbol 'ETH' and address 'address3'"},
+	}
+
+	for _, test := range tests {
+		coinID, err := index.Resolve(test.currencySymbol, test.currencyAddress, test.chainID)
+		if test.expectedErrorMsg == "" && err != nil {
+			t.Errorf("unexpected error: %v", err)
+		} else if test.expectedErrorMsg != "" {
+			if err == nil || !strings.Contains(err.Error(), test.expectedErrorMsg) {
+				t.Errorf("expected error containing: %v, got: %v", test.expectedErrorMsg, err)
+			}
+		} else if coinID != test.expectedCoinID {
+			t.Errorf("expected coin ID: %s, got: %s", test.expectedCoinID, coinID)
+		}
+	}
+}
+
+func TestCoinIndex_AmbiguousError(t *testing.T) {
+	index := NewCoinIndex([]Coin{
+		{ID: "usd-coin", Symbol: "usdc"},
+		{ID: "bridged-usdc", Symbol: "usdc"},
+	}, nil)
+
+	_, err := index.Resolve("USDC", "", "")
+
+	var ambiguousErr *AmbiguousCoinError
+	if assert.True(t, errors.As(err, &ambiguousErr), "Expected an AmbiguousCoinError") {
+		assert.Equal(t, []string{"bridged-usdc", "usd-coin"}, ambiguousErr.CoinIDs)
+	}
+}
+
+func TestCoinIndex_Lookup(t *testing.T) {
+	index := NewCoinIndex([]Coin{
+		{ID: "usd-coin", Symbol: "usdc", Platforms: map[string]string{"polygon-pos": "0x3C499c", "avalanche": "0xB97EF9"}},
+		{ID: "bridged-usdc", Symbol: "usdc"},
+	}, nil)
+
+	coinID, exists := index.LookupAddress("avalanche", "0xb97ef9")
+	assert.True(t, exists)
+	assert.Equal(t, "usd-coin", coinID)
+
+	_, exists = index.LookupAddress("ethereum", "0xb97ef9")
+	assert.False(t, exists, "Expected no match on a platform the coin is not deployed on")
+
+	assert.Equal(t, []string{"bridged-usdc", "usd-coin"}, index.LookupSymbol("USDC"))
+}
@@ -0,0 +1,219 @@
+package currency
+
+import (
+	"bdaggregator/internal/currency/coingecko"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Fetches the full CoinGecko coin list with the contract addresses of each coin
+func FetchCoinList(ctx context.Context, client *coingecko.Client) ([]Coin, error) {
+	jsonData, err := client.Get(ctx, "coins/list?include_platform=true")
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch coin list: %w", err)
+	}
+
+	var coins []Coin
+	if err := json.Unmarshal(jsonData, &coins); err != nil {
+		return nil, fmt.Errorf("failed to parse coin list: %w", err)
+	}
+	if len(coins) == 0 {
+		return nil, errors.New("CoinGecko returned an empty coin list")
+	}
+	return coins, nil
+}
+
+// CoinListDiff lists the differences between two coin lists
+type CoinListDiff struct {
+	Added   []Coin
+	Removed []Coin
+	Changed []CoinChange
+}
+
+// CoinChange is a coin whose contract addresses differ between two coin lists
+type CoinChange struct {
+	ID     string
+	Before map[string]string
+	After  map[string]string
+}
+
+func (d CoinListDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+func (d CoinListDiff) String() string {
+	return fmt.Sprintf("%d added, %d removed, %d with changed platforms", len(d.Added), len(d.Removed), len(d.Changed))
+}
+
+// Compares the coin lists by coin ID, a coin changes when a platform is added, removed or moved to another address
+func DiffCoins(before, after []Coin) CoinListDiff {
+	beforeByID := make(map[string]Coin, len(before))
+	for _, coin := range before {
+		beforeByID[coin.ID] = coin
+	}
+
+	var diff CoinListDiff
+	afterIDs := make(map[string]bool, len(after))
+	for _, coin := range after {
+		afterIDs[coin.ID] = true
+		previous, exists := beforeByID[coin.ID]
+		if !exists {
+			diff.Added = append(diff.Added, coin)
+			continue
+		}
+		if !samePlatforms(previous.Platforms, coin.Platforms) {
+			diff.Changed = append(diff.Changed, CoinChange{ID: coin.ID, Before: previous.Platforms, After: coin.Platforms})
+		}
+	}
+	for _, coin := range before {
+		if !afterIDs[coin.ID] {
+			diff.Removed = append(diff.Removed, coin)
+		}
+	}
+
+	sort.Slice(diff.Added, func(i, j int) bool { return diff.Added[i].ID < diff.Added[j].ID })
+	sort.Slice(diff.Removed, func(i, j int) bool { return diff.Removed[i].ID < diff.Removed[j].ID })
+	sort.Slice(diff.Changed, func(i, j int) bool { return diff.Changed[i].ID < diff.Changed[j].ID })
+	return diff
+}
+
+// Platforms without an address (CoinGecko lists native coins as {"": ""}) are ignored
+func samePlatforms(before, after map[string]string) bool {
+	count := 0
+	for platform, address := range after {
+		if platform == "" || address == "" {
+			continue
+		}
+		if before[platform] != address {
+			return false
+		}
+		count++
+	}
+	for platform, address := range before {
+		if platform != "" && address != "" {
+			count--
+		}
+	}
+	return count == 0
+}
+
+// Writes the coin list to a temporary file first, so a failed refresh never leaves a truncated coins.json
+func WriteCoins(filePath string, coins []Coin) error {
+	data, err := json.MarshalIndent(coins, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filePath, append(data, '\n'), ".coins-*")
+}
+
+// Writes the data to a temporary file in the same directory and renames it over the file once
+// it is synced to disk. The file keeps its permissions, a new one is readable by everyone (0644).
+func writeFileAtomic(filePath string, data []byte, pattern string) error {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(filePath); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filePath), pattern)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filePath)
+}
+
+// Fetches the current CoinGecko list and returns it with its diff to the coin list file.
+// A missing file is treated as an empty list.
+func DiffCoinList(ctx context.Context, client *coingecko.Client, filePath string) ([]Coin, CoinListDiff, error) {
+	current, err := LoadCoins(filePath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, CoinListDiff{}, fmt.Errorf("failed to load current coin list: %w", err)
+	}
+
+	coins, err := FetchCoinList(ctx, client)
+	if err != nil {
+		return nil, CoinListDiff{}, err
+	}
+	return coins, DiffCoins(current, coins), nil
+}
+
+// Replaces the coin list file with the current CoinGecko list and returns the new list with its diff.
+// A missing file is treated as an empty list.
+func RefreshCoinList(ctx context.Context, client *coingecko.Client, filePath string) ([]Coin, CoinListDiff, error) {
+	coins, diff, err := DiffCoinList(ctx, client, filePath)
+	if err != nil {
+		return nil, CoinListDiff{}, err
+	}
+	if diff.Empty() {
+		return coins, diff, nil
+	}
+	if err := WriteCoins(filePath, coins); err != nil {
+		return nil, CoinListDiff{}, fmt.Errorf("failed to write coin list: %w", err)
+	}
+	return coins, diff, nil
+}
+
+// RefreshingCoinIndex refreshes the coin list the first time a currency is not found
+// and retries the lookup with the new list. The refresh happens at most once per run.
+type RefreshingCoinIndex struct {
+	index   atomic.Pointer[CoinIndex]
+	chains  []Chain
+	refresh func() ([]Coin, error)
+	once    sync.Once
+}
+
+func NewRefreshingCoinIndex(coins []Coin, chains []Chain, refresh func() ([]Coin, error)) *RefreshingCoinIndex {
+	index := &RefreshingCoinIndex{chains: chains, refresh: refresh}
+	index.index.Store(NewCoinIndex(coins, chains))
+	return index
+}
+
+func (r *RefreshingCoinIndex) Resolve(currencySymbol, currencyAddress, chainID string) (string, error) {
+	index := r.index.Load()
+	coinID, err := index.Resolve(currencySymbol, currencyAddress, chainID)
+	var ambiguousErr *AmbiguousCoinError
+	if err == nil || errors.As(err, &ambiguousErr) {
+		return coinID, err
+	}
+
+	// Other workers wait for the refresh to finish
+	r.once.Do(func() {
+		log.Printf("Refreshing the coin list, currency not found: %v", err)
+		coins, err := r.refresh()
+		if err != nil {
+			log.Printf("Failed to refresh the coin list, keeping the current one: %v", err)
+			return
+		}
+		r.index.Store(NewCoinIndex(coins, r.chains))
+	})
+
+	refreshed := r.index.Load()
+	if refreshed == index {
+		return "", err
+	}
+	return refreshed.Resolve(currencySymbol, currencyAddress, chainID)
+}
@@ -0,0 +1,177 @@
+package currency
+
+import (
+	"bdaggregator/internal/config"
+	"bdaggregator/internal/currency/coingecko"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newCoinListServer(t *testing.T, body string) *coingecko.Client {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/coins/list", r.URL.Path)
+		assert.Equal(t, "true", r.URL.Query().Get("include_platform"))
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(mockServer.Close)
+
+	client, err := coingecko.NewClient(&config.Config{CoinGeckoAPIURL: mockServer.URL + "/", CoinGeckoRateLimit: "6000"})
+	assert.NoError(t, err)
+	return client
+}
+
+func TestDiffCoins(t *testing.T) {
+	before := []Coin{
+		{ID: "bitcoin", Symbol: "btc", Platforms: map[string]string{}},
+		{ID: "usd-coin", Symbol: "usdc", Platforms: map[string]string{"ethereum": "0xa0b8", "polygon-pos": "0x2791"}},
+		{ID: "delisted", Symbol: "del", Platforms: map[string]string{}},
+	}
+	after := []Coin{
+		{ID: "bitcoin", Symbol: "btc", Platforms: map[string]string{"": ""}},
+		{ID: "usd-coin", Symbol: "usdc", Platforms: map[string]string{"ethereum": "0xa0b8", "polygon-pos": "0x3c49", "base": "0x8335"}},
+		{ID: "new-token", Symbol: "new", Platforms: map[string]string{"base": "0x1234"}},
+	}
+
+	diff := DiffCoins(before, after)
+	assert.Equal(t, []Coin{after[2]}, diff.Added)
+	assert.Equal(t, []Coin{before[2]}, diff.Removed)
+	assert.Equal(t, []CoinChange{{ID: "usd-coin", Before: before[1].Platforms, After: after[1].Platforms}}, diff.Changed,
+		"Expected empty platforms to be ignored")
+	assert.Equal(t, "1 added, 1 removed, 1 with changed platforms", diff.String())
+
+	assert.True(t, DiffCoins(before, before).Empty())
+}
+
+func TestWriteCoins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "coins.json")
+	coins := []Coin{{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin", Platforms: map[string]string{}}}
+
+	assert.NoError(t, WriteCoins(path, coins))
+	loaded, err := LoadCoins(path)
+	assert.NoError(t, err)
+	assert.Equal(t, coins, loaded)
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "Expected no temporary file to be left behind")
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), info.Mode().Perm(), "Expected a new file to be readable by everyone")
+
+	// A rewrite keeps the permissions of the existing file
+	assert.NoError(t, os.Chmod(path, 0640))
+	assert.NoError(t, WriteCoins(path, coins))
+	info, err = os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
+
+func TestDiffCoinList_MissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "coins.json")
+	client := newCoinListServer(t, `[{"id": "bitcoin", "symbol": "btc", "name": "Bitcoin", "platforms": {}}]`)
+
+	coins, diff, err := DiffCoinList(context.Background(), client, path)
+	assert.NoError(t, err, "Expected a missing file to be treated as an empty list")
+	assert.Len(t, coins, 1)
+	assert.Equal(t, "1 added, 0 removed, 0 with changed platforms", diff.String())
+
+	_, err = os.Stat(path)
+	assert.True(t, errors.Is(err, os.ErrNotExist), "Expected the diff not to write the file")
+}
+
+func TestRefreshCoinList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "coins.json")
+	assert.NoError(t, WriteCoins(path, []Coin{{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin"}}))
+
+	client := newCoinListServer(t, `[
+		{"id": "bitcoin", "symbol": "btc", "name": "Bitcoin", "platforms": {}},
+		{"id": "sunflower-land", "symbol": "sfl", "name": "Sunflower Land", "platforms": {"polygon-pos": "0xd1f9"}}
+	]`)
+
+	coins, diff, err := RefreshCoinList(context.Background(), client, path)
+	assert.NoError(t, err)
+	assert.Len(t, coins, 2)
+	assert.Equal(t, "1 added, 0 removed, 0 with changed platforms", diff.String())
+
+	loaded, err := LoadCoins(path)
+	assert.NoError(t, err)
+	assert.Equal(t, coins, loaded)
+}
+
+func TestRefreshCoinList_KeepsFileOnEmptyList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "coins.json")
+	assert.NoError(t, WriteCoins(path, []Coin{{ID: "bitcoin", Symbol: "btc"}}))
+
+	_, _, err := RefreshCoinList(context.Background(), newCoinListServer(t, `[]`), path)
+	assert.EqualError(t, err, "CoinGecko returned an empty coin list")
+
+	loaded, err := LoadCoins(path)
+	assert.NoError(t, err)
+	assert.Len(t, loaded, 1)
+}
+
+func TestRefreshingCoinIndex(t *testing.T) {
+	coins := []Coin{
+		{ID: "bitcoin", Symbol: "btc"},
+		{ID: "sunflower-land", Symbol: "sfl", Platforms: map[string]string{"polygon-pos": "0xd1f9"}},
+		{ID: "sfl-clone", Symbol: "sfl", Platforms: map[string]string{"ethereum": "0xaaaa"}},
+	}
+	refreshed := append(coins, Coin{ID: "new-token", Symbol: "new"})
+
+	var mu sync.Mutex
+	refreshes := 0
+	index := NewRefreshingCoinIndex(coins, nil, func() ([]Coin, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		refreshes++
+		return refreshed, nil
+	})
+
+	coinID, err := index.Resolve("btc", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "bitcoin", coinID)
+	assert.Equal(t, 0, refreshes)
+
+	_, err = index.Resolve("sfl", "", "")
+	var ambiguousErr *AmbiguousCoinError
+	assert.True(t, errors.As(err, &ambiguousErr))
+	assert.Equal(t, 0, refreshes, "Expected ambiguous currencies not to trigger a refresh")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			coinID, err := index.Resolve("new", "", "")
+			assert.NoError(t, err)
+			assert.Equal(t, "new-token", coinID)
+		}()
+	}
+	wg.Wait()
+
+	_, err = index.Resolve("unknown", "", "")
+	assert.Error(t, err)
+	assert.Equal(t, 1, refreshes, "Expected the coin list to be refreshed once per run")
+}
+
+func TestRefreshingCoinIndex_RefreshFailure(t *testing.T) {
+	index := NewRefreshingCoinIndex([]Coin{{ID: "bitcoin", Symbol: "btc"}}, nil, func() ([]Coin, error) {
+		return nil, errors.New("connection refused")
+	})
+
+	_, err := index.Resolve("new", "", "")
+	assert.EqualError(t, err, "no matching coin found for symbol 'new' and address ''")
+
+	coinID, err := index.Resolve("btc", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "bitcoin", coinID)
+}
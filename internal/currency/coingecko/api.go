@@ -2,27 +2,256 @@ package coingecko
 
 import (
 	"bdaggregator/internal/config"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
 )
 
-// path should not start by "/" as url already contains it
-func ApiGet(path string) (string, error) {
-	cfg := config.LoadConfig()
-	url := cfg.CoinGeckoAPIURL + path
-	req, _ := http.NewRequest("GET", url, nil)
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultMaxRetries = 3
+	minBackoff        = time.Second
+	maxBackoff        = time.Minute
+	maxErrorBody      = 200
+)
 
-	api_key := cfg.CoinGeckoAPIKey
-	req.Header.Add("accept", "application/json")
-	req.Header.Add("x-cg-demo-api-key", api_key)
+// Plan describes how a CoinGecko API plan is accessed
+type Plan struct {
+	BaseURL        string
+	KeyHeader      string // empty for keyless access
+	CallsPerMinute int
+}
+
+var plans = map[string]Plan{
+	"public": {BaseURL: "https://api.coingecko.com/api/v3/", CallsPerMinute: 5},
+	"demo":   {BaseURL: "https://api.coingecko.com/api/v3/", KeyHeader: "x-cg-demo-api-key", CallsPerMinute: 30},
+	"pro":    {BaseURL: "https://pro-api.coingecko.com/api/v3/", KeyHeader: "x-cg-pro-api-key", CallsPerMinute: 500},
+}
+
+// Selects the plan set in SEQUENCE_COINGECKO_PLAN, defaults to "demo" when
+// an API key is set and to keyless "public" access otherwise
+func planFromConfig(cfg *config.Config) (Plan, error) {
+	name := strings.ToLower(cfg.CoinGeckoPlan)
+	if name == "" {
+		name = "public"
+		if cfg.CoinGeckoAPIKey != "" {
+			name = "demo"
+		}
+	}
+
+	plan, exists := plans[name]
+	if !exists {
+		return Plan{}, fmt.Errorf("unsupported CoinGecko plan: %s", cfg.CoinGeckoPlan)
+	}
+	if plan.KeyHeader != "" && cfg.CoinGeckoAPIKey == "" {
+		return Plan{}, fmt.Errorf("CoinGecko %s plan requires an API key", name)
+	}
+	if cfg.CoinGeckoAPIURL != "" {
+		plan.BaseURL = cfg.CoinGeckoAPIURL
+	}
+	return plan, nil
+}
+
+var (
+	ErrUnauthorized = errors.New("coingecko: invalid or missing API key")
+	ErrNotFound     = errors.New("coingecko: not found")
+	ErrRateLimited  = errors.New("coingecko: rate limit exceeded")
+)
+
+// StatusError is returned for non-2xx responses, it unwraps to ErrUnauthorized,
+// ErrNotFound or ErrRateLimited for the matching status codes
+type StatusError struct {
+	StatusCode int
+	Body       string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	body := e.Body
+	if len(body) > maxErrorBody {
+		body = body[:maxErrorBody] + "..."
+	}
+	return fmt.Sprintf("coingecko: unexpected status %d: %s", e.StatusCode, body)
+}
+
+func (e *StatusError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	}
+	return nil
+}
+
+// Client calls the CoinGecko API within the plan's rate limit, retrying
+// rate limited requests and server errors with exponential backoff
+type Client struct {
+	baseURL    string
+	keyHeader  string
+	apiKey     string
+	httpClient *http.Client
+	limiter    *rate.Limiter
+	maxRetries int
+	sleep      func(ctx context.Context, d time.Duration) error
+}
+
+func NewClient(cfg *config.Config) (*Client, error) {
+	plan, err := planFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	timeout := defaultTimeout
+	if cfg.CoinGeckoTimeout != "" {
+		parsed, err := time.ParseDuration(cfg.CoinGeckoTimeout)
+		if err != nil || parsed <= 0 {
+			return nil, fmt.Errorf("invalid CoinGecko timeout: %q", cfg.CoinGeckoTimeout)
+		}
+		timeout = parsed
+	}
 
-	res, err := http.DefaultClient.Do(req)
+	maxRetries, err := parseNonNegative(cfg.CoinGeckoMaxRetries, defaultMaxRetries)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("invalid CoinGecko max retries: %w", err)
+	}
+
+	callsPerMinute, err := parseNonNegative(cfg.CoinGeckoRateLimit, plan.CallsPerMinute)
+	if err != nil || callsPerMinute == 0 {
+		return nil, fmt.Errorf("invalid CoinGecko rate limit: %q", cfg.CoinGeckoRateLimit)
 	}
 
+	return &Client{
+		baseURL:    plan.BaseURL,
+		keyHeader:  plan.KeyHeader,
+		apiKey:     cfg.CoinGeckoAPIKey,
+		httpClient: &http.Client{Timeout: timeout},
+		limiter:    rate.NewLimiter(rate.Every(time.Minute/time.Duration(callsPerMinute)), 1),
+		maxRetries: maxRetries,
+		sleep:      sleepContext,
+	}, nil
+}
+
+// path should not start by "/" as the base url already ends with it
+func (c *Client) Get(ctx context.Context, path string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		body, err := c.do(ctx, path)
+		if err == nil {
+			return body, nil
+		}
+
+		delay, retryable := retryDelay(err, attempt)
+		if !retryable || attempt >= c.maxRetries || ctx.Err() != nil {
+			return nil, err
+		}
+
+		log.Printf("CoinGecko request %s failed, retrying in %v: %v", strings.SplitN(path, "?", 2)[0], delay, err)
+		if err := c.sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (c *Client) do(ctx context.Context, path string) ([]byte, error) {
+	if err := c.limiter.Wait(ctx); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("accept", "application/json")
+	if c.keyHeader != "" {
+		req.Header.Add(c.keyHeader, c.apiKey)
+	}
+
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
 	defer res.Body.Close()
-	body, _ := io.ReadAll(res.Body)
 
-	return string(body), nil
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, &StatusError{
+			StatusCode: res.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After")),
+		}
+	}
+	return body, nil
+}
+
+// Rate limited requests, server errors, timeouts and network failures are retried,
+// the Retry-After header takes precedence over the exponential backoff
+func retryDelay(err error, attempt int) (time.Duration, bool) {
+	backoff := minBackoff << attempt
+	if backoff > maxBackoff || backoff <= 0 {
+		backoff = maxBackoff
+	}
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) {
+		return backoff, true
+	}
+	if statusErr.StatusCode != http.StatusTooManyRequests && statusErr.StatusCode < 500 {
+		return 0, false
+	}
+	if statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter, true
+	}
+	return backoff, true
+}
+
+// Retry-After is either a number of seconds or an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+func parseNonNegative(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("%q is not a non-negative number", value)
+	}
+	return parsed, nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
 }
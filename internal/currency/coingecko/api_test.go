@@ -1,21 +1,40 @@
 package coingecko
 
 import (
+	"bdaggregator/internal/config"
+	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
-	"os"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
 )
 
-// Mock Config for tests
-func mockConfig() {
-	os.Setenv("SEQUENCE_COINGECKO_API_KEY", "test_api_key")
-	os.Setenv("SEQUENCE_COINGECKO_API_URL", "http://mockapi.com/")
-}
+// Creates a client for the mock server that records the backoff delays instead of sleeping
+func newTestClient(t *testing.T, serverURL string, cfg config.Config) (*Client, *[]time.Duration) {
+	cfg.CoinGeckoAPIURL = serverURL + "/"
+	cfg.CoinGeckoAPIKey = "test_api_key"
+	if cfg.CoinGeckoRateLimit == "" {
+		cfg.CoinGeckoRateLimit = "60000"
+	}
 
-func TestApiGet(t *testing.T) {
-	mockConfig()
+	client, err := NewClient(&cfg)
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
 
+	var delays []time.Duration
+	client.sleep = func(ctx context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return ctx.Err()
+	}
+	return client, &delays
+}
+
+func TestClient_Get(t *testing.T) {
 	// Create a mock server to simulate the CoinGecko API
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Check that the correct headers are being set
@@ -25,6 +44,9 @@ func TestApiGet(t *testing.T) {
 		if r.Header.Get("x-cg-demo-api-key") != "test_api_key" {
 			t.Errorf("expected x-cg-demo-api-key header to be test_api_key, got %s", r.Header.Get("x-cg-demo-api-key"))
 		}
+		if r.URL.Path != "/test_path" {
+			t.Errorf("expected path /test_path, got %s", r.URL.Path)
+		}
 
 		// Respond with a mock JSON response
 		w.WriteHeader(http.StatusOK)
@@ -32,17 +54,226 @@ func TestApiGet(t *testing.T) {
 	}))
 	defer mockServer.Close()
 
-	// Override CoinGecko API URL to point to the mock server
-	os.Setenv("SEQUENCE_COINGECKO_API_URL", mockServer.URL+"/")
+	client, _ := newTestClient(t, mockServer.URL, config.Config{})
 
-	response, err := ApiGet("test_path")
-	if err != nil {
-		t.Fatalf("expected no error, got %v", err)
+	response, err := client.Get(context.Background(), "test_path")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"mock": "data"}`, string(response))
+}
+
+func TestClient_TypedErrors(t *testing.T) {
+	tests := []struct {
+		status   int
+		expected error
+	}{
+		{status: http.StatusUnauthorized, expected: ErrUnauthorized},
+		{status: http.StatusNotFound, expected: ErrNotFound},
+		{status: http.StatusTooManyRequests, expected: ErrRateLimited},
+	}
+
+	for _, tt := range tests {
+		t.Run(http.StatusText(tt.status), func(t *testing.T) {
+			mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte(`{"error": "failed"}`))
+			}))
+			defer mockServer.Close()
+
+			client, _ := newTestClient(t, mockServer.URL, config.Config{CoinGeckoMaxRetries: "0"})
+
+			_, err := client.Get(context.Background(), "coins/bitcoin")
+			assert.ErrorIs(t, err, tt.expected)
+
+			var statusErr *StatusError
+			if assert.ErrorAs(t, err, &statusErr) {
+				assert.Equal(t, tt.status, statusErr.StatusCode)
+				assert.Equal(t, `{"error": "failed"}`, statusErr.Body)
+			}
+		})
+	}
+}
+
+func TestClient_RetriesRateLimitedRequests(t *testing.T) {
+	var calls atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch calls.Add(1) {
+		case 1:
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			w.Write([]byte(`{"prices": []}`))
+		}
+	}))
+	defer mockServer.Close()
+
+	client, delays := newTestClient(t, mockServer.URL, config.Config{})
+
+	response, err := client.Get(context.Background(), "coins/bitcoin")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"prices": []}`, string(response))
+	assert.Equal(t, int32(3), calls.Load())
+	assert.Equal(t, []time.Duration{7 * time.Second, 2 * time.Second}, *delays, "Expected Retry-After to be honoured, then exponential backoff")
+}
+
+func TestClient_GivesUpAfterMaxRetries(t *testing.T) {
+	var calls atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mockServer.Close()
+
+	client, delays := newTestClient(t, mockServer.URL, config.Config{CoinGeckoMaxRetries: "3"})
+
+	_, err := client.Get(context.Background(), "coins/bitcoin")
+	assert.Error(t, err)
+	assert.Equal(t, int32(4), calls.Load())
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second}, *delays)
+}
+
+func TestClient_DoesNotRetryClientErrors(t *testing.T) {
+	var calls atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer mockServer.Close()
+
+	client, _ := newTestClient(t, mockServer.URL, config.Config{})
+
+	_, err := client.Get(context.Background(), "coins/unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestClient_Timeout(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer mockServer.Close()
+
+	client, _ := newTestClient(t, mockServer.URL, config.Config{CoinGeckoTimeout: "20ms", CoinGeckoMaxRetries: "0"})
+
+	_, err := client.Get(context.Background(), "coins/bitcoin")
+	assert.Error(t, err)
+}
+
+func TestClient_ContextCancelled(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer mockServer.Close()
+
+	client, delays := newTestClient(t, mockServer.URL, config.Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := client.Get(ctx, "coins/bitcoin")
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Empty(t, *delays)
+}
+
+func TestNewClient_InvalidConfig(t *testing.T) {
+	_, err := NewClient(&config.Config{CoinGeckoTimeout: "soon"})
+	assert.EqualError(t, err, `invalid CoinGecko timeout: "soon"`)
+
+	_, err = NewClient(&config.Config{CoinGeckoMaxRetries: "-1"})
+	assert.EqualError(t, err, `invalid CoinGecko max retries: "-1" is not a non-negative number`)
+
+	_, err = NewClient(&config.Config{CoinGeckoRateLimit: "0"})
+	assert.EqualError(t, err, `invalid CoinGecko rate limit: "0"`)
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	assert.Equal(t, 30*time.Second, parseRetryAfter("30"))
+	assert.Equal(t, time.Duration(0), parseRetryAfter(""))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon"))
+
+	delay := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.InDelta(t, time.Minute, delay, float64(2*time.Second))
+}
+
+func TestPlanFromConfig(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           config.Config
+		expected      Plan
+		expectedError string
+	}{
+		{
+			name:     "Demo plan is the default with an API key",
+			cfg:      config.Config{CoinGeckoAPIKey: "key"},
+			expected: Plan{BaseURL: "https://api.coingecko.com/api/v3/", KeyHeader: "x-cg-demo-api-key", CallsPerMinute: 30},
+		},
+		{
+			name:     "Public plan is the default without an API key",
+			cfg:      config.Config{},
+			expected: Plan{BaseURL: "https://api.coingecko.com/api/v3/", CallsPerMinute: 5},
+		},
+		{
+			name:     "Pro plan",
+			cfg:      config.Config{CoinGeckoPlan: "Pro", CoinGeckoAPIKey: "key"},
+			expected: Plan{BaseURL: "https://pro-api.coingecko.com/api/v3/", KeyHeader: "x-cg-pro-api-key", CallsPerMinute: 500},
+		},
+		{
+			name:     "Custom URL overrides the plan's URL",
+			cfg:      config.Config{CoinGeckoPlan: "pro", CoinGeckoAPIKey: "key", CoinGeckoAPIURL: "http://localhost:8080/"},
+			expected: Plan{BaseURL: "http://localhost:8080/", KeyHeader: "x-cg-pro-api-key", CallsPerMinute: 500},
+		},
+		{
+			name:          "Pro plan without an API key",
+			cfg:           config.Config{CoinGeckoPlan: "pro"},
+			expectedError: "CoinGecko pro plan requires an API key",
+		},
+		{
+			name:          "Unknown plan",
+			cfg:           config.Config{CoinGeckoPlan: "enterprise"},
+			expectedError: "unsupported CoinGecko plan: enterprise",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan, err := planFromConfig(&tt.cfg)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, plan)
+		})
+	}
+}
+
+func TestClient_AuthHeaders(t *testing.T) {
+	tests := []struct {
+		plan            string
+		apiKey          string
+		expectedHeaders map[string]string
+	}{
+		{plan: "demo", apiKey: "demo_key", expectedHeaders: map[string]string{"x-cg-demo-api-key": "demo_key", "x-cg-pro-api-key": ""}},
+		{plan: "pro", apiKey: "pro_key", expectedHeaders: map[string]string{"x-cg-demo-api-key": "", "x-cg-pro-api-key": "pro_key"}},
+		{plan: "public", apiKey: "ignored_key", expectedHeaders: map[string]string{"x-cg-demo-api-key": "", "x-cg-pro-api-key": ""}},
 	}
 
-	// Check if the response matches expected mock response
-	expectedResponse := `{"mock": "data"}`
-	if response != expectedResponse {
-		t.Errorf("expected response to be %s, got %s", expectedResponse, response)
+	for _, tt := range tests {
+		t.Run(tt.plan, func(t *testing.T) {
+			mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for header, expected := range tt.expectedHeaders {
+					assert.Equal(t, expected, r.Header.Get(header), "header %s", header)
+				}
+				_, sent := r.Header["X-Cg-Demo-Api-Key"]
+				assert.Equal(t, tt.plan == "demo", sent)
+			}))
+			defer mockServer.Close()
+
+			client, err := NewClient(&config.Config{CoinGeckoPlan: tt.plan, CoinGeckoAPIKey: tt.apiKey, CoinGeckoAPIURL: mockServer.URL + "/"})
+			assert.NoError(t, err)
+
+			_, err = client.Get(context.Background(), "ping")
+			assert.NoError(t, err)
+		})
 	}
 }
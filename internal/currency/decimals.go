@@ -0,0 +1,72 @@
+package currency
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+)
+
+// Number of decimals per coin, with overrides for tokens deployed with a
+// different precision on some chains (e.g. USDC has 18 decimals on BNB Chain)
+type TokenDecimals struct {
+	Coins     map[string]int32   `json:"coins"`
+	Overrides []DecimalsOverride `json:"overrides"`
+}
+
+type DecimalsOverride struct {
+	ChainID  string `json:"chainId"`
+	Address  string `json:"address"`
+	Decimals int32  `json:"decimals"`
+}
+
+// Looks up the number of decimals used to convert raw token amounts
+type DecimalsRegistry struct {
+	coins     map[string]int32
+	overrides map[string]int32 // chain ID + lowercase address -> decimals
+}
+
+func NewDecimalsRegistry(tokenDecimals TokenDecimals) *DecimalsRegistry {
+	registry := &DecimalsRegistry{
+		coins:     make(map[string]int32, len(tokenDecimals.Coins)),
+		overrides: make(map[string]int32, len(tokenDecimals.Overrides)),
+	}
+	for coinID, decimals := range tokenDecimals.Coins {
+		registry.coins[coinID] = decimals
+	}
+	for _, override := range tokenDecimals.Overrides {
+		registry.overrides[chainAddressKey(override.ChainID, override.Address)] = override.Decimals
+	}
+	return registry
+}
+
+func LoadDecimals(filePath string) (*DecimalsRegistry, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var tokenDecimals TokenDecimals
+	decoder := json.NewDecoder(file)
+	if err := decoder.Decode(&tokenDecimals); err != nil {
+		return nil, err
+	}
+
+	return NewDecimalsRegistry(tokenDecimals), nil
+}
+
+// Return the decimals of a token, a chain/address override wins over the coin default
+func (r *DecimalsRegistry) Lookup(coinID, chainID, address string) (int32, bool) {
+	if r == nil {
+		return 0, false
+	}
+	if decimals, exists := r.overrides[chainAddressKey(chainID, address)]; exists {
+		return decimals, true
+	}
+	decimals, exists := r.coins[coinID]
+	return decimals, exists
+}
+
+func chainAddressKey(chainID, address string) string {
+	return chainID + "/" + strings.ToLower(address)
+}
@@ -0,0 +1,50 @@
+package currency
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecimalsRegistry_Lookup(t *testing.T) {
+	registry := NewDecimalsRegistry(TokenDecimals{
+		Coins: map[string]int32{"usd-coin": 6, "matic-network": 18},
+		Overrides: []DecimalsOverride{
+			{ChainID: "56", Address: "0x8AC76A51CC950D9822D68B83FE1AD97A32CD580D", Decimals: 18},
+		},
+	})
+
+	decimals, exists := registry.Lookup("usd-coin", "137", "0x3c499c542cef5e3811e1192ce70d8cc03d5c3359")
+	assert.True(t, exists)
+	assert.Equal(t, int32(6), decimals)
+
+	decimals, exists = registry.Lookup("usd-coin", "56", "0x8ac76a51cc950d9822d68b83fe1ad97a32cd580d")
+	assert.True(t, exists)
+	assert.Equal(t, int32(18), decimals, "Expected the chain/address override to win")
+
+	_, exists = registry.Lookup("unknown-coin", "137", "0x1")
+	assert.False(t, exists)
+
+	var nilRegistry *DecimalsRegistry
+	_, exists = nilRegistry.Lookup("usd-coin", "137", "")
+	assert.False(t, exists, "Expected a nil registry to know no decimals")
+}
+
+func TestLoadDecimals(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "decimals.json")
+	fileContent := `{"coins": {"matic-network": 18}, "overrides": [{"chainId": "56", "address": "0xabc", "decimals": 8}]}`
+	assert.NoError(t, os.WriteFile(filePath, []byte(fileContent), 0644))
+
+	registry, err := LoadDecimals(filePath)
+	assert.NoError(t, err)
+
+	decimals, _ := registry.Lookup("matic-network", "137", "")
+	assert.Equal(t, int32(18), decimals)
+	decimals, _ = registry.Lookup("other", "56", "0xABC")
+	assert.Equal(t, int32(8), decimals)
+
+	_, err = LoadDecimals(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
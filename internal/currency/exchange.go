@@ -2,11 +2,13 @@ package currency
 
 import (
 	"bdaggregator/internal/currency/coingecko"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
-	"strings"
+	"strconv"
 
 	"github.com/shopspring/decimal"
 )
@@ -19,29 +21,10 @@ type Coin struct {
 	Platforms map[string]string `json:"platforms"`
 }
 
+// Prices and volumes are decoded as json.Number to keep their full precision
 type PriceData struct {
-	Prices [][]interface{} `json:"prices"`
-}
-
-// MapCurrencyToCoinID maps a currency symbol and address to a coin ID using the loaded coins data
-func MapCurrencyToCoinID(coins []Coin, currencySymbol, currencyAddress, chainID string) (string, error) {
-
-	// Search for a match by currencySymbol and confirm it has the same currencyAddress
-	for _, coin := range coins {
-		if strings.EqualFold(coin.Symbol, currencySymbol) {
-			for _, address := range coin.Platforms {
-				if strings.EqualFold(address, currencyAddress) {
-					return coin.ID, nil
-				}
-			}
-		}
-	}
-
-	if currencySymbol == "MATIC" && chainID == "137" {
-		return "matic-network", nil
-	}
-
-	return "", fmt.Errorf("no matching coin found for symbol '%s' and address '%s'", currencySymbol, currencyAddress)
+	Prices       [][]json.Number `json:"prices"`
+	TotalVolumes [][]json.Number `json:"total_volumes"`
 }
 
 func LoadCoins(filePath string) ([]Coin, error) {
@@ -60,34 +43,61 @@ func LoadCoins(filePath string) ([]Coin, error) {
 	return coins, nil
 }
 
-func FetchExchangeRates(coinID, targetCurrency, from, to string) (map[int64]decimal.Decimal, error) {
-	jsonData, err := coingecko.ApiGet("coins/" + coinID + "/market_chart/range?vs_currency=" + targetCurrency + "&from=" + from + "&to=" + to + "&precision=full")
+// Fetches the rates of a coin between from and to (Unix seconds), keyed by CoinGecko's millisecond timestamps
+func FetchExchangeRates(ctx context.Context, client *coingecko.Client, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error) {
+	rates, _, err := FetchMarketChart(ctx, client, coinID, targetCurrency, from, to)
+	return rates, err
+}
+
+// Fetches the rates of a coin with the 24h volumes traded in the target currency CoinGecko
+// reports with each of them, both keyed by CoinGecko's millisecond timestamps
+func FetchMarketChart(ctx context.Context, client *coingecko.Client, coinID, targetCurrency string, from, to int64) (rates, volumes map[int64]decimal.Decimal, err error) {
+	query := url.Values{}
+	query.Set("vs_currency", targetCurrency)
+	query.Set("from", strconv.FormatInt(from, 10))
+	query.Set("to", strconv.FormatInt(to, 10))
+	query.Set("precision", "full")
 
+	jsonData, err := client.Get(ctx, "coins/"+url.PathEscape(coinID)+"/market_chart/range?"+query.Encode())
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch exchange rates: %v", err)
+		return nil, nil, fmt.Errorf("failed to fetch exchange rates: %w", err)
 	}
 
 	log.Println("Fetched exchange rates for", coinID, "from", from, "to", to, "with target currency", targetCurrency)
 
 	var priceData PriceData
-	if err := json.Unmarshal([]byte(jsonData), &priceData); err != nil {
-		log.Fatalf("failed to parse JSON: %v", err)
+	if err := json.Unmarshal(jsonData, &priceData); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse JSON: %v", err)
 	}
 
-	// Convert the exchange rates to a map
-	exchangeRateMap := transformToExchangeRateMap(priceData.Prices)
-	return exchangeRateMap, nil
+	// Convert the exchange rates and volumes to maps
+	if rates, err = transformToExchangeRateMap(priceData.Prices); err != nil {
+		return nil, nil, err
+	}
+	if volumes, err = transformToExchangeRateMap(priceData.TotalVolumes); err != nil {
+		return nil, nil, fmt.Errorf("invalid total volumes: %w", err)
+	}
+	return rates, volumes, nil
 }
 
-func transformToExchangeRateMap(prices [][]interface{}) map[int64]decimal.Decimal {
+func transformToExchangeRateMap(prices [][]json.Number) (map[int64]decimal.Decimal, error) {
 	exchangeRateMap := make(map[int64]decimal.Decimal)
 
 	for _, priceEntry := range prices {
-		timestamp := int64(priceEntry[0].(float64))
-		exchangeRate := decimal.NewFromFloat(priceEntry[1].(float64))
+		if len(priceEntry) < 2 {
+			return nil, fmt.Errorf("invalid price entry: %v", priceEntry)
+		}
+		timestamp, err := decimal.NewFromString(priceEntry[0].String())
+		if err != nil {
+			return nil, fmt.Errorf("invalid price timestamp %q: %w", priceEntry[0], err)
+		}
+		exchangeRate, err := decimal.NewFromString(priceEntry[1].String())
+		if err != nil {
+			return nil, fmt.Errorf("invalid price %q: %w", priceEntry[1], err)
+		}
 
-		exchangeRateMap[timestamp] = exchangeRate
+		exchangeRateMap[timestamp.IntPart()] = exchangeRate
 	}
 
-	return exchangeRateMap
+	return exchangeRateMap, nil
 }
@@ -1,62 +1,19 @@
 package currency
 
 import (
+	"bdaggregator/internal/config"
+	"bdaggregator/internal/currency/coingecko"
+	"context"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
-	"strings"
 	"testing"
 
 	"github.com/shopspring/decimal"
 )
 
-// Test for MapCurrencyToCoinID
-func TestMapCurrencyToCoinID(t *testing.T) {
-	coins := []Coin{
-		{
-			ID:     "bitcoin",
-			Symbol: "BTC",
-			Name:   "Bitcoin",
-			Platforms: map[string]string{
-				"platform1": "address1",
-			},
-		},
-		{
-			ID:     "matic-network",
-			Symbol: "MATIC",
-			Name:   "Polygon",
-			Platforms: map[string]string{
-				"platform2": "address2",
-			},
-		},
-	}
-
-	tests := []struct {
-		currencySymbol   string
-		currencyAddress  string
-		chainID          string
-		expectedCoinID   string
-		expectedErrorMsg string
-	}{
-		{"BTC", "address1", "", "bitcoin", ""},
-		{"MATIC", "address2", "137", "matic-network", ""},
-		{"ETH", "address3", "", "", "no matching coin found for symbol 'ETH' and address 'address3'"},
-	}
-
-	for _, test := range tests {
-		coinID, err := MapCurrencyToCoinID(coins, test.currencySymbol, test.currencyAddress, test.chainID)
-		if test.expectedErrorMsg == "" && err != nil {
-			t.Errorf("unexpected error: %v", err)
-		} else if test.expectedErrorMsg != "" {
-			if err == nil || !strings.Contains(err.Error(), test.expectedErrorMsg) {
-				t.Errorf("expected error containing: %v, got: %v", test.expectedErrorMsg, err)
-			}
-		} else if coinID != test.expectedCoinID {
-			t.Errorf("expected coin ID: %s, got: %s", test.expectedCoinID, coinID)
-		}
-	}
-}
-
 // Test for LoadCoins
 func TestLoadCoins(t *testing.T) {
 	fileContent := `[{"id": "bitcoin", "symbol": "BTC", "name": "Bitcoin", "platforms": {"platform1": "address1"}}]`
@@ -79,19 +36,25 @@ func TestLoadCoins(t *testing.T) {
 func TestFetchExchangeRates(t *testing.T) {
 	mockResponse := `{"prices": [[1609459200000, 29000.0], [1609545600000, 29500.0]]}`
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/coins/bitcoin/market_chart/range" || r.URL.Query().Get("from") != "1609459200" || r.URL.Query().Get("vs_currency") != "usd" {
+			t.Errorf("unexpected request: %s", r.URL)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.Write([]byte(mockResponse))
 	}))
 	defer mockServer.Close()
 
-	os.Setenv("SEQUENCE_COINGECKO_API_URL", mockServer.URL+"/")
+	client, err := coingecko.NewClient(&config.Config{CoinGeckoAPIURL: mockServer.URL + "/", CoinGeckoRateLimit: "6000"})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
 
 	coinID := "bitcoin"
 	targetCurrency := "usd"
-	from := "1609459200"
-	to := "1609545600"
+	var from int64 = 1609459200
+	var to int64 = 1609545600
 
-	exchangeRates, err := FetchExchangeRates(coinID, targetCurrency, from, to)
+	exchangeRates, err := FetchExchangeRates(context.Background(), client, coinID, targetCurrency, from, to)
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
 	}
@@ -107,3 +70,67 @@ func TestFetchExchangeRates(t *testing.T) {
 		}
 	}
 }
+
+func TestFetchMarketChart(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"prices": [[1609459200000, 29000.0]], "total_volumes": [[1609459200000, 41000000000.5]]}`))
+	}))
+	defer mockServer.Close()
+
+	client, err := coingecko.NewClient(&config.Config{CoinGeckoAPIURL: mockServer.URL + "/", CoinGeckoRateLimit: "6000"})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	rates, volumes, err := FetchMarketChart(context.Background(), client, "bitcoin", "usd", 1609459200, 1609545600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rate := rates[1609459200000]; rate.String() != "29000" {
+		t.Errorf("unexpected rate: %s", rate)
+	}
+	if volume := volumes[1609459200000]; volume.String() != "41000000000.5" {
+		t.Errorf("expected the volume traded with the rate, got: %s", volume)
+	}
+}
+
+func TestTransformToExchangeRateMapKeepsPrecision(t *testing.T) {
+	prices := [][]json.Number{
+		{"1712102400000", "0.99981234567890123456"},
+		{"1.712106e12", "1"},
+	}
+
+	exchangeRates, err := transformToExchangeRateMap(prices)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if rate := exchangeRates[1712102400000]; rate.String() != "0.99981234567890123456" {
+		t.Errorf("expected full precision rate, got: %s", rate)
+	}
+	if _, exists := exchangeRates[1712106000000]; !exists {
+		t.Errorf("expected timestamp in exponent notation to be parsed")
+	}
+
+	if _, err := transformToExchangeRateMap([][]json.Number{{"1712102400000"}}); err == nil {
+		t.Errorf("expected an error for an incomplete price entry")
+	}
+}
+
+func TestFetchExchangeRates_StatusError(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"status": {"error_code": 10002, "error_message": "API Key Missing"}}`))
+	}))
+	defer mockServer.Close()
+
+	client, err := coingecko.NewClient(&config.Config{CoinGeckoAPIURL: mockServer.URL + "/", CoinGeckoRateLimit: "6000"})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+
+	_, err = FetchExchangeRates(context.Background(), client, "bitcoin", "usd", 1609459200, 1609545600)
+	if !errors.Is(err, coingecko.ErrUnauthorized) {
+		t.Errorf("expected an unauthorized error, got: %v", err)
+	}
+}
@@ -0,0 +1,177 @@
+package currency
+
+import (
+	"bdaggregator/internal/config"
+	"bdaggregator/internal/currency/coingecko"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+)
+
+// ErrRatesNotFound is returned by a provider that has no rates for a coin and target currency
+var ErrRatesNotFound = errors.New("exchange rates not found")
+
+// RateProvider returns exchange rates of a coin keyed by Unix timestamp in seconds.
+// The rates cover the requested range, a provider may return points around it
+// so that events at both ends of the range can be priced.
+type RateProvider interface {
+	Name() string
+	FetchRates(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error)
+}
+
+// VolumeProvider is implemented by providers that also serve the volumes traded in the
+// target currency, keyed by the timestamps of the rates they were reported with
+type VolumeProvider interface {
+	FetchRatesWithVolumes(ctx context.Context, coinID, targetCurrency string, from, to int64) (rates, volumes map[int64]decimal.Decimal, err error)
+}
+
+// Fetches the rates with their volumes from a provider serving them, the volumes are nil otherwise
+func FetchRatesWithVolumes(ctx context.Context, provider RateProvider, coinID, targetCurrency string, from, to int64) (rates, volumes map[int64]decimal.Decimal, err error) {
+	if volumeProvider, ok := provider.(VolumeProvider); ok {
+		return volumeProvider.FetchRatesWithVolumes(ctx, coinID, targetCurrency, from, to)
+	}
+	rates, err = provider.FetchRates(ctx, coinID, targetCurrency, from, to)
+	return rates, nil, err
+}
+
+// SourceReporter is implemented by providers combining several sources,
+// it names the source that served the last rates of a coin
+type SourceReporter interface {
+	Source(coinID, targetCurrency string) string
+}
+
+// Names the source of the rates a provider returned for a coin
+func RateSource(provider RateProvider, coinID, targetCurrency string) string {
+	if reporter, ok := provider.(SourceReporter); ok {
+		if source := reporter.Source(coinID, targetCurrency); source != "" {
+			return source
+		}
+	}
+	return provider.Name()
+}
+
+// Builds the ordered fallback chain of providers listed in SEQUENCE_RATE_PROVIDERS,
+// CoinGecko is used when the list is empty. In offline mode the rates snapshot is
+// the only provider.
+func NewRateProvider(cfg *config.Config) (RateProvider, error) {
+	if cfg.Offline {
+		provider, err := LoadSnapshotProvider(cfg.RatesSnapshotPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load rate snapshot for offline mode: %w", err)
+		}
+		return provider, nil
+	}
+
+	names := strings.Split(cfg.RateProviders, ",")
+	if strings.TrimSpace(cfg.RateProviders) == "" {
+		names = []string{"coingecko"}
+	}
+
+	var providers []RateProvider
+	for _, name := range names {
+		var provider RateProvider
+		var err error
+
+		switch strings.TrimSpace(name) {
+		case "coingecko":
+			provider, err = newCachedCoinGeckoProvider(cfg)
+		case "file":
+			provider, err = LoadFileProvider(cfg.RatesFilePath)
+		case "peg":
+			provider, err = NewPegProvider(cfg.RatePegs)
+		default:
+			return nil, fmt.Errorf("unsupported rate provider: %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s rate provider: %w", name, err)
+		}
+		providers = append(providers, provider)
+	}
+
+	if len(providers) == 1 {
+		return providers[0], nil
+	}
+	return NewFallbackProvider(providers...), nil
+}
+
+func newCachedCoinGeckoProvider(cfg *config.Config) (RateProvider, error) {
+	client, err := coingecko.NewClient(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := newCoinGeckoProviderFromConfig(client, cfg.CoinGeckoGranularity, cfg.CoinGeckoWorkers)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RateCacheDir == "" {
+		return provider, nil
+	}
+	return NewCachedProvider(provider, NewRateCache(cfg.RateCacheDir), provider.Resolution()), nil
+}
+
+// FallbackProvider asks each provider in order and returns the first non-empty rates,
+// so a coin missing on one source is filled from the next one
+type FallbackProvider struct {
+	providers []RateProvider
+	sources   sync.Map // series key -> name of the provider that served it
+}
+
+func NewFallbackProvider(providers ...RateProvider) *FallbackProvider {
+	return &FallbackProvider{providers: providers}
+}
+
+func (p *FallbackProvider) Name() string {
+	names := make([]string, 0, len(p.providers))
+	for _, provider := range p.providers {
+		names = append(names, provider.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (p *FallbackProvider) FetchRates(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error) {
+	rates, _, err := p.fetch(ctx, coinID, targetCurrency, from, to, false)
+	return rates, err
+}
+
+// The volumes come from the provider serving the rates, they are nil if it has none
+func (p *FallbackProvider) FetchRatesWithVolumes(ctx context.Context, coinID, targetCurrency string, from, to int64) (rates, volumes map[int64]decimal.Decimal, err error) {
+	return p.fetch(ctx, coinID, targetCurrency, from, to, true)
+}
+
+func (p *FallbackProvider) fetch(ctx context.Context, coinID, targetCurrency string, from, to int64, withVolumes bool) (map[int64]decimal.Decimal, map[int64]decimal.Decimal, error) {
+	var errs []error
+	for _, provider := range p.providers {
+		var rates, volumes map[int64]decimal.Decimal
+		var err error
+		if withVolumes {
+			rates, volumes, err = FetchRatesWithVolumes(ctx, provider, coinID, targetCurrency, from, to)
+		} else {
+			rates, err = provider.FetchRates(ctx, coinID, targetCurrency, from, to)
+		}
+		if err == nil && len(rates) == 0 {
+			err = ErrRatesNotFound
+		}
+		if err != nil {
+			if !errors.Is(err, ErrRatesNotFound) {
+				log.Printf("Rate provider %s failed for %s, trying the next one: %v", provider.Name(), coinID, err)
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+			continue
+		}
+		p.sources.Store(rateSeriesKey(coinID, targetCurrency), provider.Name())
+		return rates, volumes, nil
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+func (p *FallbackProvider) Source(coinID, targetCurrency string) string {
+	source, _ := p.sources.Load(rateSeriesKey(coinID, targetCurrency))
+	name, _ := source.(string)
+	return name
+}
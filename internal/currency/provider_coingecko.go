@@ -0,0 +1,140 @@
+package currency
+
+import (
+	"bdaggregator/internal/currency/coingecko"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/shopspring/decimal"
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	// CoinGecko returns hourly rates for ranges up to 90 days and daily rates beyond that
+	hourlyWindow            int64 = 90 * 24 * 60 * 60
+	defaultCoinGeckoWorkers       = 4
+)
+
+// CoinGeckoProvider serves historical rates from the CoinGecko market_chart/range endpoint.
+// Long ranges are split into windows short enough to keep the requested granularity.
+type CoinGeckoProvider struct {
+	client  *coingecko.Client
+	window  int64 // 0 fetches the whole range at once
+	workers int
+}
+
+func NewCoinGeckoProvider(client *coingecko.Client, window int64, workers int) *CoinGeckoProvider {
+	if workers < 1 {
+		workers = 1
+	}
+	return &CoinGeckoProvider{client: client, window: window, workers: workers}
+}
+
+// Maps the granularity set in SEQUENCE_COINGECKO_GRANULARITY to the longest window keeping it
+func coinGeckoWindow(granularity string) (int64, error) {
+	switch strings.ToLower(granularity) {
+	case "", "hourly":
+		return hourlyWindow, nil
+	case "daily":
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("unsupported CoinGecko granularity: %s", granularity)
+	}
+}
+
+func newCoinGeckoProviderFromConfig(client *coingecko.Client, granularity, workers string) (*CoinGeckoProvider, error) {
+	window, err := coinGeckoWindow(granularity)
+	if err != nil {
+		return nil, err
+	}
+
+	numWorkers := defaultCoinGeckoWorkers
+	if workers != "" {
+		if numWorkers, err = strconv.Atoi(workers); err != nil || numWorkers < 1 {
+			return nil, fmt.Errorf("invalid CoinGecko workers: %q", workers)
+		}
+	}
+	return NewCoinGeckoProvider(client, window, numWorkers), nil
+}
+
+// Seconds between the served rates, windows of up to 90 days keep hourly rates
+func (p *CoinGeckoProvider) Resolution() int64 {
+	if p.window > 0 && p.window <= hourlyWindow {
+		return 60 * 60
+	}
+	return rateCacheBucket
+}
+
+func (p *CoinGeckoProvider) Name() string {
+	return "coingecko"
+}
+
+func (p *CoinGeckoProvider) FetchRates(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error) {
+	rates, _, err := p.FetchRatesWithVolumes(ctx, coinID, targetCurrency, from, to)
+	return rates, err
+}
+
+// Fetches the windows concurrently and stitches them back into one series, the volumes come
+// with the rates in the same response. CoinGecko timestamps are in milliseconds, they are
+// converted to seconds to match event timestamps.
+func (p *CoinGeckoProvider) FetchRatesWithVolumes(ctx context.Context, coinID, targetCurrency string, from, to int64) (rates, volumes map[int64]decimal.Decimal, err error) {
+	windows := splitRange(from, to, p.window)
+	if len(windows) > 1 {
+		log.Printf("Fetching exchange rates for %s in %d windows", coinID, len(windows))
+	}
+
+	rates = make(map[int64]decimal.Decimal)
+	volumes = make(map[int64]decimal.Decimal)
+	mu := sync.Mutex{}
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(p.workers)
+
+	for _, window := range windows {
+		group.Go(func() error {
+			exchangeRates, totalVolumes, err := FetchMarketChart(groupCtx, p.client, coinID, targetCurrency, window[0], window[1])
+			if err != nil {
+				return err
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for timestampMs, rate := range exchangeRates {
+				rates[timestampMs/1000] = rate
+			}
+			for timestampMs, volume := range totalVolumes {
+				volumes[timestampMs/1000] = volume
+			}
+			return nil
+		})
+	}
+
+	if err := group.Wait(); err != nil {
+		if errors.Is(err, coingecko.ErrNotFound) {
+			return nil, nil, fmt.Errorf("%w: %w", ErrRatesNotFound, err)
+		}
+		return nil, nil, err
+	}
+	return rates, volumes, nil
+}
+
+// Splits [from, to] into consecutive windows no longer than size, size 0 keeps the range whole
+func splitRange(from, to, size int64) [][2]int64 {
+	if size <= 0 || to-from <= size {
+		return [][2]int64{{from, to}}
+	}
+
+	var windows [][2]int64
+	for start := from; start <= to; start += size {
+		end := start + size - 1
+		if end > to {
+			end = to
+		}
+		windows = append(windows, [2]int64{start, end})
+	}
+	return windows
+}
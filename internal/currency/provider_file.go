@@ -0,0 +1,192 @@
+package currency
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Columns of a rates file, CSV files need a header with these names
+var rateFileColumns = []string{"coin_id", "currency", "timestamp", "rate"}
+
+// RateRecord is a single rate of a rates file. The timestamp is a Unix timestamp
+// in seconds, an RFC 3339 time or a date (2006-01-02).
+type RateRecord struct {
+	CoinID    string          `json:"coin_id"`
+	Currency  string          `json:"currency"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	Rate      json.Number     `json:"rate"`
+}
+
+type ratePoint struct {
+	timestamp int64
+	rate      decimal.Decimal
+}
+
+// FileProvider serves rates loaded from a local CSV or JSON file
+type FileProvider struct {
+	series map[string][]ratePoint
+}
+
+// Loads a rates file, the format is detected from the extension (.csv or .json)
+func LoadFileProvider(filePath string) (*FileProvider, error) {
+	if filePath == "" {
+		return nil, fmt.Errorf("rates file path is not set")
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return NewFileProvider(file, strings.TrimPrefix(strings.ToLower(filepath.Ext(filePath)), "."))
+}
+
+func NewFileProvider(r io.Reader, format string) (*FileProvider, error) {
+	provider := &FileProvider{series: make(map[string][]ratePoint)}
+
+	var err error
+	switch format {
+	case "csv":
+		err = provider.readCSV(r)
+	case "json":
+		err = provider.readJSON(r)
+	default:
+		return nil, fmt.Errorf("unsupported rates file format: %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, points := range provider.series {
+		sort.Slice(points, func(i, j int) bool { return points[i].timestamp < points[j].timestamp })
+	}
+	return provider, nil
+}
+
+func (p *FileProvider) Name() string {
+	return "file"
+}
+
+// Returns the rates within the range and the closest rate on each side of it
+func (p *FileProvider) FetchRates(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error) {
+	points, exists := p.series[rateSeriesKey(coinID, targetCurrency)]
+	if !exists {
+		return nil, fmt.Errorf("%w for %s in %s", ErrRatesNotFound, coinID, targetCurrency)
+	}
+
+	start := sort.Search(len(points), func(i int) bool { return points[i].timestamp >= from })
+	end := sort.Search(len(points), func(i int) bool { return points[i].timestamp > to })
+	if start > 0 {
+		start--
+	}
+	if end < len(points) {
+		end++
+	}
+
+	rates := make(map[int64]decimal.Decimal, end-start)
+	for _, point := range points[start:end] {
+		rates[point.timestamp] = point.rate
+	}
+	return rates, nil
+}
+
+func (p *FileProvider) readCSV(r io.Reader) error {
+	reader := csv.NewReader(r)
+	header, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read rates file header: %w", err)
+	}
+
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, name := range rateFileColumns {
+		if _, exists := columns[name]; !exists {
+			return fmt.Errorf("rates file is missing required column %q", name)
+		}
+	}
+
+	for line := 2; ; line++ {
+		row, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read rates file: %w", err)
+		}
+
+		record := RateRecord{
+			CoinID:    row[columns["coin_id"]],
+			Currency:  row[columns["currency"]],
+			Timestamp: json.RawMessage(row[columns["timestamp"]]),
+			Rate:      json.Number(row[columns["rate"]]),
+		}
+		if err := p.add(record); err != nil {
+			return fmt.Errorf("invalid rate on line %d: %w", line, err)
+		}
+	}
+}
+
+func (p *FileProvider) readJSON(r io.Reader) error {
+	var records []RateRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return fmt.Errorf("failed to parse rates file: %w", err)
+	}
+
+	for i, record := range records {
+		if err := p.add(record); err != nil {
+			return fmt.Errorf("invalid rate at index %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+func (p *FileProvider) add(record RateRecord) error {
+	if record.CoinID == "" || record.Currency == "" {
+		return fmt.Errorf("coin_id and currency are required")
+	}
+
+	timestamp, err := parseRateTimestamp(strings.Trim(string(record.Timestamp), `"`))
+	if err != nil {
+		return err
+	}
+
+	rate, err := decimal.NewFromString(record.Rate.String())
+	if err != nil {
+		return fmt.Errorf("invalid rate %q: %w", record.Rate, err)
+	}
+
+	key := rateSeriesKey(record.CoinID, record.Currency)
+	p.series[key] = append(p.series[key], ratePoint{timestamp: timestamp, rate: rate})
+	return nil
+}
+
+func parseRateTimestamp(value string) (int64, error) {
+	if unix, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return unix, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.Unix(), nil
+	}
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t.Unix(), nil
+	}
+	return 0, fmt.Errorf("invalid timestamp %q", value)
+}
+
+func rateSeriesKey(coinID, targetCurrency string) string {
+	return coinID + "/" + strings.ToLower(targetCurrency)
+}
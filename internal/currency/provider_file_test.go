@@ -0,0 +1,79 @@
+package currency
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileProvider_CSV(t *testing.T) {
+	data := "timestamp,coin_id,currency,rate\n" +
+		"1712102400,matic-network,usd,0.9512\n" +
+		"2024-04-02,matic-network,usd,0.9401\n" +
+		"2024-04-04T00:00:00Z,matic-network,USD,0.9733\n" +
+		"1712361600,matic-network,usd,0.9810\n" +
+		"1712102400,matic-network,eur,0.8812\n"
+
+	provider, err := NewFileProvider(strings.NewReader(data), "csv")
+	assert.NoError(t, err)
+
+	// The range is padded with the closest rate on each side
+	rates, err := provider.FetchRates(context.Background(), "matic-network", "usd", 1712102400, 1712150000)
+	assert.NoError(t, err)
+	assert.Len(t, rates, 3)
+	assert.Equal(t, "0.9401", rates[1712016000].String())
+	assert.Equal(t, "0.9512", rates[1712102400].String())
+	assert.Equal(t, "0.9733", rates[1712188800].String())
+
+	rates, err = provider.FetchRates(context.Background(), "matic-network", "EUR", 1712102400, 1712102400)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.8812", rates[1712102400].String())
+
+	_, err = provider.FetchRates(context.Background(), "bitcoin", "usd", 1712102400, 1712102400)
+	assert.ErrorIs(t, err, ErrRatesNotFound)
+}
+
+func TestFileProvider_JSON(t *testing.T) {
+	data := `[
+		{"coin_id": "usd-coin", "currency": "usd", "timestamp": 1712102400, "rate": 0.99981234567890123456},
+		{"coin_id": "usd-coin", "currency": "usd", "timestamp": "2024-04-04", "rate": "1.0002"}
+	]`
+
+	provider, err := NewFileProvider(strings.NewReader(data), "json")
+	assert.NoError(t, err)
+
+	rates, err := provider.FetchRates(context.Background(), "usd-coin", "usd", 1712102400, 1712188800)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.99981234567890123456", rates[1712102400].String())
+	assert.Equal(t, "1.0002", rates[1712188800].String())
+}
+
+func TestFileProvider_InvalidFiles(t *testing.T) {
+	_, err := NewFileProvider(strings.NewReader("coin_id,currency,rate\n"), "csv")
+	assert.EqualError(t, err, `rates file is missing required column "timestamp"`)
+
+	_, err = NewFileProvider(strings.NewReader("coin_id,currency,timestamp,rate\nbitcoin,usd,yesterday,1\n"), "csv")
+	assert.EqualError(t, err, `invalid rate on line 2: invalid timestamp "yesterday"`)
+
+	_, err = NewFileProvider(strings.NewReader(`[{"coin_id": "bitcoin", "timestamp": 1, "rate": 1}]`), "json")
+	assert.EqualError(t, err, "invalid rate at index 0: coin_id and currency are required")
+
+	_, err = NewFileProvider(strings.NewReader(""), "xml")
+	assert.EqualError(t, err, `unsupported rates file format: "xml"`)
+}
+
+func TestLoadFileProvider(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "rates.CSV")
+	assert.NoError(t, os.WriteFile(filePath, []byte("coin_id,currency,timestamp,rate\nbitcoin,usd,1712102400,65000\n"), 0644))
+
+	provider, err := LoadFileProvider(filePath)
+	assert.NoError(t, err)
+
+	rates, err := provider.FetchRates(context.Background(), "bitcoin", "usd", 1712102400, 1712102400)
+	assert.NoError(t, err)
+	assert.Equal(t, "65000", rates[1712102400].String())
+}
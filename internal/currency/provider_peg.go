@@ -0,0 +1,74 @@
+package currency
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/shopspring/decimal"
+)
+
+// PegTable holds the constant rates of pegged assets, e.g. stablecoins to USD
+type PegTable map[string]decimal.Decimal
+
+// Parses pegs in the "coin-id:currency=rate" form separated by commas,
+// e.g. "usd-coin:usd=1,tether:usd=1"
+func ParsePegs(pegs string) (PegTable, error) {
+	table := make(PegTable)
+
+	for _, entry := range strings.Split(pegs, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		pair, value, found := strings.Cut(entry, "=")
+		coinID, targetCurrency, hasCurrency := strings.Cut(pair, ":")
+		if !found || !hasCurrency || coinID == "" || targetCurrency == "" {
+			return nil, fmt.Errorf("invalid peg %q, expected coin-id:currency=rate", entry)
+		}
+
+		rate, err := decimal.NewFromString(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid peg rate %q: %w", value, err)
+		}
+		if !rate.IsPositive() {
+			return nil, fmt.Errorf("invalid peg rate %q: must be positive", value)
+		}
+		table[rateSeriesKey(strings.TrimSpace(coinID), strings.TrimSpace(targetCurrency))] = rate
+	}
+
+	return table, nil
+}
+
+// Returns the pegged rate of a coin in the target currency
+func (t PegTable) Rate(coinID, targetCurrency string) (decimal.Decimal, bool) {
+	rate, exists := t[rateSeriesKey(coinID, targetCurrency)]
+	return rate, exists
+}
+
+// PegProvider serves the pegged rates as a provider, so pegs can be part of a fallback chain
+type PegProvider struct {
+	pegs PegTable
+}
+
+func NewPegProvider(pegs string) (*PegProvider, error) {
+	table, err := ParsePegs(pegs)
+	if err != nil {
+		return nil, err
+	}
+	return &PegProvider{pegs: table}, nil
+}
+
+func (p *PegProvider) Name() string {
+	return "peg"
+}
+
+// Returns the pegged rate at both ends of the range
+func (p *PegProvider) FetchRates(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error) {
+	rate, exists := p.pegs.Rate(coinID, targetCurrency)
+	if !exists {
+		return nil, fmt.Errorf("%w for %s in %s", ErrRatesNotFound, coinID, targetCurrency)
+	}
+	return map[int64]decimal.Decimal{from: rate, to: rate}, nil
+}
@@ -0,0 +1,245 @@
+package currency
+
+import (
+	"bdaggregator/internal/config"
+	"bdaggregator/internal/currency/coingecko"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+type stubProvider struct {
+	name  string
+	rates map[string]map[int64]decimal.Decimal
+	err   error
+	calls int
+}
+
+func (p *stubProvider) Name() string {
+	return p.name
+}
+
+func (p *stubProvider) FetchRates(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error) {
+	p.calls++
+	if p.err != nil {
+		return nil, p.err
+	}
+	rates, exists := p.rates[coinID]
+	if !exists {
+		return nil, ErrRatesNotFound
+	}
+	return rates, nil
+}
+
+func TestFallbackProvider(t *testing.T) {
+	primary := &stubProvider{name: "primary", rates: map[string]map[int64]decimal.Decimal{
+		"bitcoin": {1712102400: decimal.NewFromInt(65000)},
+		"empty":   {},
+	}}
+	secondary := &stubProvider{name: "secondary", rates: map[string]map[int64]decimal.Decimal{
+		"bitcoin":  {1712102400: decimal.NewFromInt(1)},
+		"usd-coin": {1712102400: decimal.NewFromInt(1)},
+		"empty":    {1712102400: decimal.NewFromInt(2)},
+	}}
+	provider := NewFallbackProvider(primary, secondary)
+
+	assert.Equal(t, "primary,secondary", provider.Name())
+
+	rates, err := provider.FetchRates(context.Background(), "bitcoin", "usd", 1712102400, 1712102400)
+	assert.NoError(t, err)
+	assert.Equal(t, "65000", rates[1712102400].String(), "Expected the first provider to win")
+
+	rates, err = provider.FetchRates(context.Background(), "usd-coin", "usd", 1712102400, 1712102400)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", rates[1712102400].String(), "Expected a missing coin to be filled from the next provider")
+
+	rates, err = provider.FetchRates(context.Background(), "empty", "usd", 1712102400, 1712102400)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", rates[1712102400].String(), "Expected empty rates to be treated as missing")
+
+	_, err = provider.FetchRates(context.Background(), "unknown", "usd", 1712102400, 1712102400)
+	assert.ErrorIs(t, err, ErrRatesNotFound)
+
+	assert.Equal(t, "primary", RateSource(provider, "bitcoin", "usd"))
+	assert.Equal(t, "secondary", RateSource(provider, "usd-coin", "USD"), "Expected the provider that served the rates")
+	assert.Equal(t, "primary,secondary", RateSource(provider, "unknown", "usd"))
+	assert.Equal(t, "primary", RateSource(primary, "bitcoin", "usd"))
+}
+
+func TestFallbackProvider_ProviderError(t *testing.T) {
+	failing := &stubProvider{name: "failing", err: errors.New("connection refused")}
+	secondary := &stubProvider{name: "secondary", rates: map[string]map[int64]decimal.Decimal{
+		"bitcoin": {1712102400: decimal.NewFromInt(65000)},
+	}}
+
+	rates, err := NewFallbackProvider(failing, secondary).FetchRates(context.Background(), "bitcoin", "usd", 1712102400, 1712102400)
+	assert.NoError(t, err)
+	assert.Len(t, rates, 1)
+
+	_, err = NewFallbackProvider(failing).FetchRates(context.Background(), "bitcoin", "usd", 1712102400, 1712102400)
+	assert.EqualError(t, err, "failing: connection refused")
+}
+
+func TestPegProvider(t *testing.T) {
+	provider, err := NewPegProvider("usd-coin:usd=1, tether:USD=1.0, usd-coin:eur=0.92")
+	assert.NoError(t, err)
+
+	rates, err := provider.FetchRates(context.Background(), "usd-coin", "usd", 1712102400, 1712188800)
+	assert.NoError(t, err)
+	assert.Equal(t, map[int64]decimal.Decimal{1712102400: decimal.NewFromInt(1), 1712188800: decimal.NewFromInt(1)}, rates)
+
+	rates, err = provider.FetchRates(context.Background(), "usd-coin", "EUR", 1712102400, 1712102400)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.92", rates[1712102400].String())
+
+	_, err = provider.FetchRates(context.Background(), "bitcoin", "usd", 1712102400, 1712102400)
+	assert.ErrorIs(t, err, ErrRatesNotFound)
+
+	_, err = NewPegProvider("usd-coin=1")
+	assert.EqualError(t, err, `invalid peg "usd-coin=1", expected coin-id:currency=rate`)
+
+	_, err = NewPegProvider("usd-coin:usd=one")
+	assert.Error(t, err)
+
+	_, err = NewPegProvider("usd-coin:usd=0")
+	assert.EqualError(t, err, `invalid peg rate "0": must be positive`)
+}
+
+func TestPegTable_Rate(t *testing.T) {
+	pegs, err := ParsePegs("usd-coin:usd=1, dai:usd=1.0")
+	assert.NoError(t, err)
+
+	rate, exists := pegs.Rate("dai", "USD")
+	assert.True(t, exists)
+	assert.Equal(t, "1", rate.String())
+
+	_, exists = pegs.Rate("dai", "eur")
+	assert.False(t, exists, "Expected pegs to apply only to their target currency")
+}
+
+func TestCoinGeckoProvider_NormalizesTimestamps(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/coins/unknown/market_chart/range" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(`{"prices": [[1712102400000, 0.9998], [1712106000000, 1.0001]]}`))
+	}))
+	defer mockServer.Close()
+
+	client, err := coingecko.NewClient(&config.Config{CoinGeckoAPIURL: mockServer.URL + "/", CoinGeckoRateLimit: "6000"})
+	assert.NoError(t, err)
+
+	rates, err := NewCoinGeckoProvider(client, 0, 1).FetchRates(context.Background(), "usd-coin", "usd", 1712102400, 1712106000)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.9998", rates[1712102400].String())
+	assert.Equal(t, "1.0001", rates[1712106000].String())
+
+	_, err = NewCoinGeckoProvider(client, 0, 1).FetchRates(context.Background(), "unknown", "usd", 1712102400, 1712106000)
+	assert.ErrorIs(t, err, ErrRatesNotFound, "Expected a 404 to let the next provider fill the coin")
+}
+
+func TestNewRateProvider(t *testing.T) {
+	provider, err := NewRateProvider(&config.Config{})
+	assert.NoError(t, err)
+	assert.IsType(t, &CoinGeckoProvider{}, provider)
+
+	provider, err = NewRateProvider(&config.Config{RateProviders: "peg, coingecko", RatePegs: "usd-coin:usd=1"})
+	assert.NoError(t, err)
+	assert.Equal(t, "peg,coingecko", provider.Name())
+
+	_, err = NewRateProvider(&config.Config{RateProviders: "file"})
+	assert.EqualError(t, err, "failed to create file rate provider: rates file path is not set")
+
+	_, err = NewRateProvider(&config.Config{RateProviders: "binance"})
+	assert.EqualError(t, err, "unsupported rate provider: binance")
+}
+
+func TestCoinGeckoProvider_ChunksLongRanges(t *testing.T) {
+	var mu sync.Mutex
+	var windows [][2]int64
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.ParseInt(r.URL.Query().Get("from"), 10, 64)
+		to, _ := strconv.ParseInt(r.URL.Query().Get("to"), 10, 64)
+		mu.Lock()
+		windows = append(windows, [2]int64{from, to})
+		mu.Unlock()
+
+		// One rate at the start and the end of each window
+		fmt.Fprintf(w, `{"prices": [[%d, 1], [%d, 2]]}`, from*1000, to*1000)
+	}))
+	defer mockServer.Close()
+
+	client, err := coingecko.NewClient(&config.Config{CoinGeckoAPIURL: mockServer.URL + "/", CoinGeckoRateLimit: "6000"})
+	assert.NoError(t, err)
+	provider, err := newCoinGeckoProviderFromConfig(client, "hourly", "2")
+	assert.NoError(t, err)
+
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+	to := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC).Unix()
+	rates, err := provider.FetchRates(context.Background(), "bitcoin", "usd", from, to)
+	assert.NoError(t, err)
+
+	sort.Slice(windows, func(i, j int) bool { return windows[i][0] < windows[j][0] })
+	assert.Len(t, windows, 3)
+	assert.Equal(t, from, windows[0][0])
+	assert.Equal(t, to, windows[2][1])
+	for i, window := range windows {
+		assert.LessOrEqual(t, window[1]-window[0], hourlyWindow, "Window %d is too long for hourly rates", i)
+		if i > 0 {
+			assert.Equal(t, windows[i-1][1]+1, window[0], "Expected windows to be contiguous")
+		}
+	}
+	assert.Len(t, rates, 6, "Expected the windows to be stitched into one series")
+
+	// Daily granularity fetches the whole range at once
+	windows = nil
+	provider, err = newCoinGeckoProviderFromConfig(client, "daily", "")
+	assert.NoError(t, err)
+	_, err = provider.FetchRates(context.Background(), "bitcoin", "usd", from, to)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]int64{{from, to}}, windows)
+}
+
+func TestCoinGeckoProvider_WindowError(t *testing.T) {
+	var calls atomic.Int32
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 2 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"prices": [[1712102400000, 1]]}`))
+	}))
+	defer mockServer.Close()
+
+	client, err := coingecko.NewClient(&config.Config{CoinGeckoAPIURL: mockServer.URL + "/", CoinGeckoRateLimit: "6000"})
+	assert.NoError(t, err)
+
+	_, err = NewCoinGeckoProvider(client, 100, 1).FetchRates(context.Background(), "bitcoin", "usd", 0, 1000)
+	assert.ErrorIs(t, err, coingecko.ErrUnauthorized, "Expected a failed window to fail the whole range")
+}
+
+func TestNewCoinGeckoProviderFromConfig_Invalid(t *testing.T) {
+	_, err := newCoinGeckoProviderFromConfig(nil, "minutely", "")
+	assert.EqualError(t, err, "unsupported CoinGecko granularity: minutely")
+
+	_, err = newCoinGeckoProviderFromConfig(nil, "", "0")
+	assert.EqualError(t, err, `invalid CoinGecko workers: "0"`)
+}
+
+func TestSplitRange(t *testing.T) {
+	assert.Equal(t, [][2]int64{{0, 100}}, splitRange(0, 100, 0))
+	assert.Equal(t, [][2]int64{{0, 100}}, splitRange(0, 100, 100))
+	assert.Equal(t, [][2]int64{{0, 99}, {100, 199}, {200, 250}}, splitRange(0, 250, 100))
+}
@@ -0,0 +1,239 @@
+package currency
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Version of the rate snapshot format, bumped on incompatible changes
+const RateSnapshotVersion = 1
+
+// RateSnapshot is a self-contained set of rates for rerunning aggregations offline
+type RateSnapshot struct {
+	Version   int                  `json:"version"`
+	CreatedAt time.Time            `json:"createdAt"`
+	Series    []RateSnapshotSeries `json:"series"`
+}
+
+// RateSnapshotSeries holds the rates of a coin in a target currency, the volumes traded
+// with them if the source had any, and the time ranges they cover completely
+type RateSnapshotSeries struct {
+	CoinID   string                    `json:"coinId"`
+	Currency string                    `json:"currency"`
+	Coverage []TimeRange               `json:"coverage"`
+	Rates    map[int64]decimal.Decimal `json:"rates"`
+	Volumes  map[int64]decimal.Decimal `json:"volumes,omitempty"`
+}
+
+// TimeRange spans Unix seconds from From to To, both included
+type TimeRange struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+func (r TimeRange) String() string {
+	return time.Unix(r.From, 0).UTC().Format(time.RFC3339) + " - " + time.Unix(r.To, 0).UTC().Format(time.RFC3339)
+}
+
+// MissingRatesError lists the parts of a requested range a snapshot doesn't cover
+type MissingRatesError struct {
+	CoinID   string
+	Currency string
+	Missing  []TimeRange
+}
+
+func (e *MissingRatesError) Error() string {
+	ranges := make([]string, 0, len(e.Missing))
+	for _, missing := range e.Missing {
+		ranges = append(ranges, missing.String())
+	}
+	return fmt.Sprintf("rates for %s in %s missing from the snapshot: %s", e.CoinID, e.Currency, strings.Join(ranges, ", "))
+}
+
+// Builds a snapshot of the series sorted by coin and target currency
+func NewRateSnapshot(series []RateSnapshotSeries, createdAt time.Time) *RateSnapshot {
+	snapshot := &RateSnapshot{Version: RateSnapshotVersion, CreatedAt: createdAt.UTC(), Series: series}
+	sort.Slice(snapshot.Series, func(i, j int) bool {
+		if snapshot.Series[i].CoinID != snapshot.Series[j].CoinID {
+			return snapshot.Series[i].CoinID < snapshot.Series[j].CoinID
+		}
+		return snapshot.Series[i].Currency < snapshot.Series[j].Currency
+	})
+	return snapshot
+}
+
+func LoadRateSnapshot(filePath string) (*RateSnapshot, error) {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshot RateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("invalid rate snapshot: %w", err)
+	}
+	if snapshot.Version != RateSnapshotVersion {
+		return nil, fmt.Errorf("unsupported rate snapshot version %d, expected %d", snapshot.Version, RateSnapshotVersion)
+	}
+	return &snapshot, nil
+}
+
+// Writes the snapshot to a temporary file first, so an interrupted export never leaves a partial snapshot
+func (s *RateSnapshot) Write(filePath string) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filePath, data, ".snapshot-*")
+}
+
+// Builds a snapshot from the cached days, a day counts as covered only if it has rates,
+// as a day without them may have been cached by a failed fetch.
+// Empty filters export every coin and target currency.
+func (c *RateCache) Snapshot(coinID, targetCurrency string, createdAt time.Time) (*RateSnapshot, error) {
+	series := make(map[string]*RateSnapshotSeries)
+	days := make(map[string][]int64)
+
+	err := c.walk(func(coin, currency string, day time.Time, path string, info os.FileInfo) error {
+		if coinID != "" && coin != coinID {
+			return nil
+		}
+		if targetCurrency != "" && currency != strings.ToLower(targetCurrency) {
+			return nil
+		}
+
+		cached, _, err := c.load(coin, currency, day.Unix())
+		if err != nil {
+			return fmt.Errorf("failed to read cached rates of %s on %s: %w", coin, day.Format("2006-01-02"), err)
+		}
+
+		if len(cached.Rates) == 0 {
+			return nil
+		}
+
+		key := rateSeriesKey(coin, currency)
+		if _, exists := series[key]; !exists {
+			series[key] = &RateSnapshotSeries{CoinID: coin, Currency: currency, Rates: make(map[int64]decimal.Decimal)}
+		}
+		for timestamp, rate := range cached.Rates {
+			series[key].Rates[timestamp] = rate
+		}
+		if len(cached.Volumes) > 0 && series[key].Volumes == nil {
+			series[key].Volumes = make(map[int64]decimal.Decimal)
+		}
+		for timestamp, volume := range cached.Volumes {
+			series[key].Volumes[timestamp] = volume
+		}
+		days[key] = append(days[key], day.Unix())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	snapshotSeries := make([]RateSnapshotSeries, 0, len(series))
+	for key, entry := range series {
+		sort.Slice(days[key], func(i, j int) bool { return days[key][i] < days[key][j] })
+		for _, run := range contiguousBuckets(days[key]) {
+			entry.Coverage = append(entry.Coverage, TimeRange{From: run[0], To: run[len(run)-1] + rateCacheBucket - 1})
+		}
+		snapshotSeries = append(snapshotSeries, *entry)
+	}
+	return NewRateSnapshot(snapshotSeries, createdAt), nil
+}
+
+// SnapshotProvider serves rates only from a snapshot, a range the snapshot doesn't
+// cover fails with a MissingRatesError instead of falling back to the network.
+// A covered range without rates fails with ErrRatesNotFound, like the source did.
+type SnapshotProvider struct {
+	series map[string]RateSnapshotSeries
+}
+
+func NewSnapshotProvider(snapshot *RateSnapshot) *SnapshotProvider {
+	provider := &SnapshotProvider{series: make(map[string]RateSnapshotSeries, len(snapshot.Series))}
+	for _, series := range snapshot.Series {
+		provider.series[rateSeriesKey(series.CoinID, series.Currency)] = series
+	}
+	return provider
+}
+
+func LoadSnapshotProvider(filePath string) (*SnapshotProvider, error) {
+	if filePath == "" {
+		return nil, fmt.Errorf("rate snapshot path is not set")
+	}
+	snapshot, err := LoadRateSnapshot(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return NewSnapshotProvider(snapshot), nil
+}
+
+func (p *SnapshotProvider) Name() string {
+	return "snapshot"
+}
+
+func (p *SnapshotProvider) FetchRates(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error) {
+	rates, _, err := p.FetchRatesWithVolumes(ctx, coinID, targetCurrency, from, to)
+	return rates, err
+}
+
+// Returns the rates and volumes of the whole days around the range, as the cache of the
+// exporting run did. The volumes are nil if the snapshot has none for the coin.
+func (p *SnapshotProvider) FetchRatesWithVolumes(ctx context.Context, coinID, targetCurrency string, from, to int64) (rates, volumes map[int64]decimal.Decimal, err error) {
+	series := p.series[rateSeriesKey(coinID, targetCurrency)]
+	if missing := uncovered(TimeRange{From: from, To: to}, series.Coverage); len(missing) > 0 {
+		return nil, nil, &MissingRatesError{CoinID: coinID, Currency: strings.ToLower(targetCurrency), Missing: missing}
+	}
+
+	rangeFrom, rangeTo := bucketStart(from), bucketStart(to)+rateCacheBucket-1
+	rates = make(map[int64]decimal.Decimal)
+	for timestamp, rate := range series.Rates {
+		if timestamp >= rangeFrom && timestamp <= rangeTo {
+			rates[timestamp] = rate
+		}
+	}
+	if series.Volumes != nil {
+		volumes = make(map[int64]decimal.Decimal)
+		for timestamp, volume := range series.Volumes {
+			if timestamp >= rangeFrom && timestamp <= rangeTo {
+				volumes[timestamp] = volume
+			}
+		}
+	}
+	if len(rates) == 0 {
+		return nil, nil, fmt.Errorf("%w for %s in %s in the snapshot", ErrRatesNotFound, coinID, strings.ToLower(targetCurrency))
+	}
+	return rates, volumes, nil
+}
+
+// Returns the parts of the requested range outside of the coverage
+func uncovered(requested TimeRange, coverage []TimeRange) []TimeRange {
+	sorted := append([]TimeRange(nil), coverage...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].From < sorted[j].From })
+
+	var missing []TimeRange
+	next := requested.From
+	for _, covered := range sorted {
+		if next > requested.To {
+			break
+		}
+		if covered.To < next {
+			continue
+		}
+		if covered.From > next {
+			missing = append(missing, TimeRange{From: next, To: min(covered.From-1, requested.To)})
+		}
+		next = max(next, covered.To+1)
+	}
+	if next <= requested.To {
+		missing = append(missing, TimeRange{From: next, To: requested.To})
+	}
+	return missing
+}
@@ -0,0 +1,135 @@
+package currency
+
+import (
+	"bdaggregator/internal/config"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateCache_Snapshot(t *testing.T) {
+	day := func(d int) int64 { return time.Date(2024, 4, d, 0, 0, 0, 0, time.UTC).Unix() }
+	provider, cache := newTestCachedProvider(t, &rangeRecorder{}, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+
+	// April 1-2 and April 4 are cached, April 3 is not
+	_, err := provider.FetchRates(context.Background(), "bitcoin", "usd", day(1), day(2)+60)
+	assert.NoError(t, err)
+	_, err = provider.FetchRates(context.Background(), "bitcoin", "usd", day(4), day(4)+60)
+	assert.NoError(t, err)
+	_, err = provider.FetchRates(context.Background(), "ethereum", "eur", day(1), day(1)+60)
+	assert.NoError(t, err)
+	// An empty day cached by an earlier version isn't covered
+	assert.NoError(t, cache.store("bitcoin", "usd", day(3), map[int64]decimal.Decimal{}, nil, 3600, time.Now()))
+
+	createdAt := time.Date(2024, 5, 2, 12, 0, 0, 0, time.UTC)
+	snapshot, err := cache.Snapshot("", "", createdAt)
+	assert.NoError(t, err)
+	assert.Equal(t, RateSnapshotVersion, snapshot.Version)
+	assert.Equal(t, createdAt, snapshot.CreatedAt)
+	if assert.Len(t, snapshot.Series, 2) {
+		assert.Equal(t, "bitcoin", snapshot.Series[0].CoinID)
+		assert.Equal(t, []TimeRange{{From: day(1), To: day(3) - 1}, {From: day(4), To: day(5) - 1}}, snapshot.Series[0].Coverage)
+		assert.Len(t, snapshot.Series[0].Rates, 3*24)
+		assert.Equal(t, "ethereum", snapshot.Series[1].CoinID)
+	}
+
+	filtered, err := cache.Snapshot("", "EUR", createdAt)
+	assert.NoError(t, err)
+	assert.Len(t, filtered.Series, 1)
+
+	// The snapshot survives a round trip through the file
+	path := filepath.Join(t.TempDir(), "rates_snapshot.json")
+	assert.NoError(t, snapshot.Write(path))
+	loaded, err := LoadRateSnapshot(path)
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot.Series[0].Coverage, loaded.Series[0].Coverage)
+	assert.Len(t, loaded.Series[0].Rates, 3*24)
+}
+
+func TestSnapshotProvider_Volumes(t *testing.T) {
+	day := func(d int) int64 { return time.Date(2024, 4, d, 0, 0, 0, 0, time.UTC).Unix() }
+	provider, cache := newTestCachedProvider(t, &volumeRecorder{}, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	_, _, err := provider.FetchRatesWithVolumes(context.Background(), "bitcoin", "usd", day(1), day(1)+60)
+	assert.NoError(t, err)
+
+	snapshot, err := cache.Snapshot("", "", time.Now())
+	assert.NoError(t, err)
+	if assert.Len(t, snapshot.Series, 1) {
+		assert.Len(t, snapshot.Series[0].Volumes, 24)
+	}
+
+	rates, volumes, err := NewSnapshotProvider(snapshot).FetchRatesWithVolumes(context.Background(), "bitcoin", "usd", day(1), day(1)+60)
+	assert.NoError(t, err)
+	assert.Len(t, rates, 24)
+	assert.Equal(t, "10", volumes[day(1)+3600].String())
+}
+
+func TestLoadRateSnapshot_Version(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates_snapshot.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"version": 2, "series": []}`), 0644))
+
+	_, err := LoadRateSnapshot(path)
+	assert.EqualError(t, err, "unsupported rate snapshot version 2, expected 1")
+}
+
+func TestSnapshotProvider(t *testing.T) {
+	day := func(d int) int64 { return time.Date(2024, 4, d, 0, 0, 0, 0, time.UTC).Unix() }
+	provider, cache := newTestCachedProvider(t, &rangeRecorder{}, time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC))
+	_, err := provider.FetchRates(context.Background(), "bitcoin", "usd", day(1), day(2)+60)
+	assert.NoError(t, err)
+	_, err = provider.FetchRates(context.Background(), "bitcoin", "usd", day(4), day(4)+60)
+	assert.NoError(t, err)
+
+	snapshot, err := cache.Snapshot("", "", time.Now())
+	assert.NoError(t, err)
+	offline := NewSnapshotProvider(snapshot)
+
+	// Whole days are served like the cache served them to the exporting run
+	rates, err := offline.FetchRates(context.Background(), "bitcoin", "USD", day(1)+3600, day(1)+7200)
+	assert.NoError(t, err)
+	assert.Len(t, rates, 24)
+
+	_, err = offline.FetchRates(context.Background(), "bitcoin", "usd", day(2)+3600, day(5)+3600)
+	var missingErr *MissingRatesError
+	if assert.True(t, errors.As(err, &missingErr)) {
+		assert.Equal(t, []TimeRange{{From: day(3), To: day(4) - 1}, {From: day(5), To: day(5) + 3600}}, missingErr.Missing)
+	}
+	assert.False(t, errors.Is(err, ErrRatesNotFound), "Expected missing rates to fail the run instead of leaving events unpriced")
+	assert.EqualError(t, err, "rates for bitcoin in usd missing from the snapshot: "+
+		"2024-04-03T00:00:00Z - 2024-04-03T23:59:59Z, 2024-04-05T00:00:00Z - 2024-04-05T01:00:00Z")
+
+	// A covered range without rates had none at the source
+	_, err = NewSnapshotProvider(NewRateSnapshot([]RateSnapshotSeries{
+		{CoinID: "delisted", Currency: "usd", Coverage: []TimeRange{{From: day(1), To: day(2)}}, Rates: map[int64]decimal.Decimal{}},
+	}, time.Now())).FetchRates(context.Background(), "delisted", "usd", day(1), day(2))
+	assert.True(t, errors.Is(err, ErrRatesNotFound))
+
+	_, err = offline.FetchRates(context.Background(), "ethereum", "usd", day(1), day(1))
+	assert.EqualError(t, err, "rates for ethereum in usd missing from the snapshot: 2024-04-01T00:00:00Z - 2024-04-01T00:00:00Z")
+}
+
+func TestUncovered(t *testing.T) {
+	coverage := []TimeRange{{From: 50, To: 59}, {From: 10, To: 19}, {From: 20, To: 29}}
+
+	assert.Empty(t, uncovered(TimeRange{From: 12, To: 25}, coverage))
+	assert.Equal(t, []TimeRange{{From: 5, To: 9}, {From: 30, To: 49}, {From: 60, To: 70}}, uncovered(TimeRange{From: 5, To: 70}, coverage))
+	assert.Equal(t, []TimeRange{{From: 35, To: 40}}, uncovered(TimeRange{From: 35, To: 40}, coverage))
+}
+
+func TestNewRateProvider_Offline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rates_snapshot.json")
+	assert.NoError(t, (&RateSnapshot{Version: RateSnapshotVersion}).Write(path))
+
+	provider, err := NewRateProvider(&config.Config{Offline: true, RatesSnapshotPath: path, RateProviders: "coingecko"})
+	assert.NoError(t, err)
+	assert.Equal(t, "snapshot", provider.Name(), "Expected the snapshot to replace every other provider offline")
+
+	_, err = NewRateProvider(&config.Config{Offline: true})
+	assert.EqualError(t, err, "failed to load rate snapshot for offline mode: rate snapshot path is not set")
+}
@@ -2,26 +2,88 @@ package bigquery
 
 import (
 	"bdaggregator/internal/config"
+	"bdaggregator/internal/etl"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"sort"
+	"time"
 
 	bg "cloud.google.com/go/bigquery"
+	"cloud.google.com/go/civil"
 	"google.golang.org/api/googleapi"
 )
 
 type BigQueryDB struct {
-	client *bg.Client
-	cfg    *config.Config
+	client  *bg.Client
+	cfg     *config.Config
+	numeric numericFormat
 }
 
+// Row of the 'aggregation' table as sent to the MERGE query, the day and the
+// volume are passed as strings and cast to DATE and NUMERIC/BIGNUMERIC by BigQuery
+type aggregationRow struct {
+	Day                            string
+	ProjectID                      int
+	NumberOfTransactionsPerProject int
+	TotalVolumePerProject          string
+	Currency                       string
+	RateProviders                  string
+	MinRateTimestamp               bg.NullTimestamp
+	MaxRateTimestamp               bg.NullTimestamp
+	DistinctRates                  int
+	UnpricedEvents                 int
+}
+
+// Row of the 'rates' table as sent to the MERGE query
+type rateRow struct {
+	CoinID    string
+	Currency  string
+	Timestamp time.Time
+	Rate      string
+	Source    string
+}
+
+// Row of the 'events' table as loaded from NDJSON
+type eventRow struct {
+	Timestamp            time.Time
+	ProjectID            int
+	Event                string
+	CoinID               string
+	ChainID              string `json:",omitempty"`
+	TxnHash              string `json:",omitempty"`
+	CurrencyValueRaw     string `json:",omitempty"`
+	CurrencyValueDecimal string
+	Currency             string
+	ExchangeRate         string `json:",omitempty"`
+	Volume               string `json:",omitempty"`
+	UnpricedReason       string `json:",omitempty"`
+	RateSource           string `json:",omitempty"`
+}
+
+// Number of rows merged by a single query
+const mergeBatchSize = 10000
+
+// Lifetime of a staging table left behind by a failed run
+const stagingExpiration = 24 * time.Hour
+
+// Decimal places of BIGNUMERIC, rates and event values with more are rejected by BigQuery
+const bigNumericScale = 38
+
 // Create a new instance of BigQueryDB
 func NewBigQueryDB(ctx context.Context, cfg *config.Config) (*BigQueryDB, error) {
+	numeric, err := newNumericFormat(cfg.BigQueryNumericType, cfg.VolumeScale, cfg.VolumeRounding)
+	if err != nil {
+		return nil, err
+	}
+
 	client, err := bg.NewClient(ctx, cfg.BigQueryProject)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create BigQuery client: %v", err)
 	}
-	return &BigQueryDB{client: client, cfg: cfg}, nil
+	return &BigQueryDB{client: client, cfg: cfg, numeric: numeric}, nil
 }
 
 func (bq *BigQueryDB) SetupDatabase(ctx context.Context) error {
@@ -41,67 +103,268 @@ func (bq *BigQueryDB) SetupDatabase(ctx context.Context) error {
 }
 
 func (bq *BigQueryDB) SetupTable(ctx context.Context, tableName string) error {
-	var bqSchema bg.Schema
-	switch tableName {
-	case "aggregation":
-		bqSchema = GetAggregationSchema()
-	default:
-		return fmt.Errorf("unsupported table name: %s", tableName)
+	descriptor, err := bq.table(tableName)
+	if err != nil {
+		return err
 	}
 
 	table := bq.client.Dataset(bq.cfg.BigQueryDataset).Table(tableName)
-	_, err := table.Metadata(ctx)
+	metadata, err := table.Metadata(ctx)
 	if err != nil {
 		if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == 404 {
-			if err := table.Create(ctx, &bg.TableMetadata{Schema: bqSchema}); err != nil {
+			if err := table.Create(ctx, descriptor.metadata()); err != nil {
 				return fmt.Errorf("failed to create table %s: %v", tableName, err)
 			}
 			log.Println("Table created successfully.")
 		} else {
 			return fmt.Errorf("failed to get table metadata for %s: %v", tableName, err)
 		}
+		return nil
+	}
+
+	if err := checkFieldTypes(tableName, metadata.Schema, descriptor.schema); err != nil {
+		return err
+	}
+
+	// Columns added to the schema since the table was created are appended to it
+	if missing := missingFields(metadata.Schema, descriptor.schema); len(missing) > 0 {
+		update := bg.TableMetadataToUpdate{Schema: append(metadata.Schema, missing...)}
+		if _, err := table.Update(ctx, update, metadata.ETag); err != nil {
+			return fmt.Errorf("failed to add columns to table %s: %v", tableName, err)
+		}
+		log.Printf("Added %d columns to table %s.", len(missing), tableName)
 	}
 	return nil
 }
 
-func (bq *BigQueryDB) Upsert(ctx context.Context, tableName string, records interface{}) error {
-	table := fmt.Sprintf("`%s.%s.%s`", bq.cfg.BigQueryProject, bq.cfg.BigQueryDataset, tableName)
+func (bq *BigQueryDB) table(tableName string) (tableDescriptor, error) {
+	switch tableName {
+	case "aggregation":
+		return aggregationTable(bq.numeric.fieldType), nil
+	case "rates":
+		return ratesTable(), nil
+	case "events":
+		return eventsTable(), nil
+	default:
+		return tableDescriptor{}, fmt.Errorf("unsupported table name: %s", tableName)
+	}
+}
 
-	query := bq.client.Query(fmt.Sprintf(`
-		MERGE INTO %s AS target
-		USING UNNEST(@records) AS source
-		ON target.Day = DATE(source.Day) AND target.ProjectID = source.ProjectID
-		WHEN MATCHED THEN
-			UPDATE SET
-				target.NumberOfTransactionsPerProject = source.NumberOfTransactionsPerProject,
-				target.TotalVolumePerProject = CAST(source.TotalVolumePerProject AS NUMERIC),
-				target.Currency = source.Currency
-		WHEN NOT MATCHED THEN
-			INSERT (Day, ProjectID, NumberOfTransactionsPerProject, TotalVolumePerProject, Currency)
-			VALUES(DATE(source.Day), source.ProjectID, source.NumberOfTransactionsPerProject, CAST(source.TotalVolumePerProject AS NUMERIC), source.Currency)`, table))
+// Fully qualified name of the table for queries
+func (bq *BigQueryDB) tableRef(tableName string) string {
+	return fmt.Sprintf("`%s.%s.%s`", bq.cfg.BigQueryProject, bq.cfg.BigQueryDataset, tableName)
+}
 
+func (bq *BigQueryDB) UpsertAggregations(ctx context.Context, aggregates []etl.AggregatePerProject) error {
+	return merge(ctx, bq, aggregationTable(bq.numeric.fieldType), bq.aggregationRows(aggregates))
+}
+
+func (bq *BigQueryDB) UpsertRates(ctx context.Context, rates []etl.ExchangeRate) error {
+	return merge(ctx, bq, ratesTable(), rateRows(rates))
+}
+
+// Merges the rows in batches to keep each query under BigQuery's request size limit
+func merge[R any](ctx context.Context, bq *BigQueryDB, table tableDescriptor, rows []R) error {
+	mergeQuery, err := table.mergeQuery(bq.tableRef(table.name))
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(rows); start += mergeBatchSize {
+		query := bq.client.Query(mergeQuery)
+		query.Parameters = []bg.QueryParameter{
+			{Name: "records", Value: rows[start:min(start+mergeBatchSize, len(rows))]},
+		}
+		if err := runQuery(ctx, query); err != nil {
+			return fmt.Errorf("failed to merge into table %s: %v", table.name, err)
+		}
+	}
+
+	log.Printf("%d rows successfully merged into %s.", len(rows), table.name)
+	return nil
+}
+
+// Events have no natural key, so the events of the days and currencies in the run are
+// replaced, the same days the MERGE replaces in the 'aggregation' table. The events are
+// loaded into a staging table first and swapped in by a single transaction, so a failed
+// load leaves the previous events in place.
+func (bq *BigQueryDB) ReplaceEvents(ctx context.Context, events []etl.EventRecord) error {
+	if len(events) == 0 {
+		return nil
+	}
+	table := eventsTable()
+
+	// The staging table expires on its own if the run dies before dropping it
+	stagingName := fmt.Sprintf("%s_staging_%d", table.name, time.Now().UnixNano())
+	staging := bq.client.Dataset(bq.cfg.BigQueryDataset).Table(stagingName)
+	metadata := table.metadata()
+	metadata.ExpirationTime = time.Now().Add(stagingExpiration)
+	if err := staging.Create(ctx, metadata); err != nil {
+		return fmt.Errorf("failed to create staging table %s: %v", stagingName, err)
+	}
+	defer func() {
+		if err := staging.Delete(context.WithoutCancel(ctx)); err != nil {
+			log.Printf("Failed to drop staging table %s, it expires on its own: %v", stagingName, err)
+		}
+	}()
+
+	// Rows are encoded while they are uploaded, so the NDJSON of a large run is never held in memory
+	reader, writer := io.Pipe()
+	go func() {
+		writer.CloseWithError(writeEventRows(writer, events))
+	}()
+	defer reader.Close()
+
+	source := bg.NewReaderSource(reader)
+	source.SourceFormat = bg.JSON
+	loader := staging.LoaderFrom(source)
+	loader.WriteDisposition = bg.WriteTruncate
+
+	job, err := loader.Run(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to run events load: %v", err)
+	}
+	if err := waitForJob(ctx, job); err != nil {
+		return fmt.Errorf("failed to load events into staging table %s: %v", stagingName, err)
+	}
+
+	replaceQuery, err := table.replaceQuery(bq.tableRef(table.name), bq.tableRef(stagingName))
+	if err != nil {
+		return err
+	}
+	days, currencies := eventPartitions(events)
+	query := bq.client.Query(replaceQuery)
 	query.Parameters = []bg.QueryParameter{
-		{Name: "records", Value: records},
+		{Name: "days", Value: days},
+		{Name: "currencies", Value: currencies},
+	}
+	if err := runQuery(ctx, query); err != nil {
+		return fmt.Errorf("failed to replace events: %v", err)
 	}
 
+	log.Printf("%d events successfully loaded into BigQuery.", len(events))
+	return nil
+}
+
+func runQuery(ctx context.Context, query *bg.Query) error {
 	job, err := query.Run(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to run merge query: %v", err)
+		return fmt.Errorf("failed to run query: %v", err)
 	}
+	return waitForJob(ctx, job)
+}
 
-	// Wait for the query to complete
+func waitForJob(ctx context.Context, job *bg.Job) error {
 	status, err := job.Wait(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to wait for merge job completion: %v", err)
+		return fmt.Errorf("failed to wait for job completion: %v", err)
 	}
 	if err := status.Err(); err != nil {
-		return fmt.Errorf("merge job failed with error: %v", err)
+		return fmt.Errorf("job failed with error: %v", err)
 	}
+	return nil
+}
 
-	log.Println("Records successfully merged into BigQuery.")
+// Rounds the exact volumes to the configured scale, this is the only place where rounding happens
+func (bq *BigQueryDB) aggregationRows(aggregates []etl.AggregatePerProject) []aggregationRow {
+	rows := make([]aggregationRow, 0, len(aggregates))
+	for _, aggregate := range aggregates {
+		rows = append(rows, aggregationRow{
+			Day:                            aggregate.Day,
+			ProjectID:                      aggregate.ProjectID,
+			NumberOfTransactionsPerProject: aggregate.NumberOfTransactionsPerProject,
+			TotalVolumePerProject:          bq.numeric.format(aggregate.TotalVolumePerProject),
+			Currency:                       aggregate.Currency,
+			RateProviders:                  aggregate.RateProviders,
+			MinRateTimestamp:               nullTimestamp(aggregate.MinRateTimestamp),
+			MaxRateTimestamp:               nullTimestamp(aggregate.MaxRateTimestamp),
+			DistinctRates:                  aggregate.DistinctRates,
+			UnpricedEvents:                 aggregate.UnpricedEvents,
+		})
+	}
+	return rows
+}
+
+// Rates keep their full precision, they are sent as strings and cast to BIGNUMERIC
+func rateRows(rates []etl.ExchangeRate) []rateRow {
+	rows := make([]rateRow, 0, len(rates))
+	for _, rate := range rates {
+		rows = append(rows, rateRow{
+			CoinID:    rate.CoinID,
+			Currency:  rate.Currency,
+			Timestamp: time.Unix(rate.Timestamp, 0).UTC(),
+			Rate:      rate.Rate.Round(bigNumericScale).String(),
+			Source:    rate.Source,
+		})
+	}
+	return rows
+}
+
+// Days and currencies the events belong to, sorted
+func eventPartitions(events []etl.EventRecord) ([]civil.Date, []string) {
+	days := make(map[civil.Date]bool)
+	currencies := make(map[string]bool)
+	for _, event := range events {
+		days[civil.DateOf(event.Ts.UTC())] = true
+		currencies[event.Currency] = true
+	}
+
+	sortedDays := make([]civil.Date, 0, len(days))
+	for day := range days {
+		sortedDays = append(sortedDays, day)
+	}
+	sort.Slice(sortedDays, func(i, j int) bool { return sortedDays[i].Before(sortedDays[j]) })
+
+	sortedCurrencies := make([]string, 0, len(currencies))
+	for currency := range currencies {
+		sortedCurrencies = append(sortedCurrencies, currency)
+	}
+	sort.Strings(sortedCurrencies)
+	return sortedDays, sortedCurrencies
+}
+
+// Writes the events as NDJSON rows of the 'events' table
+func writeEventRows(w io.Writer, events []etl.EventRecord) error {
+	encoder := json.NewEncoder(w)
+	for _, event := range events {
+		if err := encoder.Encode(newEventRow(event)); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// Decimals are written as strings to keep their precision, BIGNUMERIC holds up to 38
+// decimal places. Empty values are left out and loaded as NULL.
+func newEventRow(event etl.EventRecord) eventRow {
+	row := eventRow{
+		Timestamp:            event.Ts.UTC(),
+		ProjectID:            event.ProjectID,
+		Event:                event.Event,
+		CoinID:               event.CoinID,
+		ChainID:              event.ChainID,
+		TxnHash:              event.TxnHash,
+		CurrencyValueRaw:     event.CurrencyValueRaw,
+		CurrencyValueDecimal: event.CurrencyValueDecimal.Round(bigNumericScale).String(),
+		Currency:             event.Currency,
+		UnpricedReason:       event.UnpricedReason,
+		RateSource:           event.RateSource,
+	}
+	if !event.Unpriced {
+		row.ExchangeRate = event.ExchangeRate.Round(bigNumericScale).String()
+		row.Volume = event.Volume.Round(bigNumericScale).String()
+	}
+	return row
+}
+
+// Zero stands for no timestamp, e.g. a day priced with pegged rates only
+func nullTimestamp(unix int64) bg.NullTimestamp {
+	if unix == 0 {
+		return bg.NullTimestamp{}
+	}
+	return bg.NullTimestamp{Timestamp: time.Unix(unix, 0).UTC(), Valid: true}
+}
+
 func (bq *BigQueryDB) Close() error {
 	return bq.client.Close()
 }
@@ -0,0 +1,63 @@
+package bigquery
+
+import (
+	"bdaggregator/internal/etl"
+	"bytes"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/civil"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteEventRows(t *testing.T) {
+	ts := time.Date(2024, 4, 15, 2, 15, 7, 167000000, time.UTC)
+	events := []etl.EventRecord{
+		{
+			Ts:                   ts,
+			ProjectID:            4974,
+			Event:                "BUY_ITEMS",
+			CoinID:               "sunflower-land",
+			ChainID:              "137",
+			TxnHash:              "0xd919",
+			CurrencyValueRaw:     "613620341167824900",
+			CurrencyValueDecimal: decimal.RequireFromString("0.6136203411678249"),
+			Currency:             "usd",
+			ExchangeRate:         decimal.RequireFromString("0.05"),
+			Volume:               decimal.RequireFromString("0.030681017058391245"),
+			RateSource:           "coingecko",
+		},
+		{
+			Ts:                   ts,
+			ProjectID:            4974,
+			Event:                "BUY_ITEMS",
+			CoinID:               "sunflower-land",
+			CurrencyValueDecimal: decimal.RequireFromString("1"),
+			Currency:             "usd",
+			Unpriced:             true,
+			UnpricedReason:       etl.UnpricedNoRates,
+		},
+	}
+
+	var buf bytes.Buffer
+	assert.NoError(t, writeEventRows(&buf, events))
+	assert.Equal(t, `{"Timestamp":"2024-04-15T02:15:07.167Z","ProjectID":4974,"Event":"BUY_ITEMS","CoinID":"sunflower-land",`+
+		`"ChainID":"137","TxnHash":"0xd919","CurrencyValueRaw":"613620341167824900","CurrencyValueDecimal":"0.6136203411678249",`+
+		`"Currency":"usd","ExchangeRate":"0.05","Volume":"0.030681017058391245","RateSource":"coingecko"}
+{"Timestamp":"2024-04-15T02:15:07.167Z","ProjectID":4974,"Event":"BUY_ITEMS","CoinID":"sunflower-land",`+
+		`"CurrencyValueDecimal":"1","Currency":"usd","UnpricedReason":"`+etl.UnpricedNoRates+`"}
+`, buf.String(), "Expected unpriced events to load without a rate and volume")
+}
+
+func TestEventPartitions(t *testing.T) {
+	events := []etl.EventRecord{
+		{Ts: time.Date(2024, 4, 16, 10, 0, 0, 0, time.UTC), Currency: "usd"},
+		{Ts: time.Date(2024, 4, 15, 23, 59, 59, 0, time.UTC), Currency: "usd"},
+		{Ts: time.Date(2024, 4, 16, 1, 0, 0, 0, time.UTC), Currency: "eur"},
+	}
+
+	days, currencies := eventPartitions(events)
+	assert.Equal(t, []civil.Date{{Year: 2024, Month: 4, Day: 15}, {Year: 2024, Month: 4, Day: 16}}, days)
+	assert.Equal(t, []string{"eur", "usd"}, currencies)
+}
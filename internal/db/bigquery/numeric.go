@@ -0,0 +1,72 @@
+package bigquery
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	bg "cloud.google.com/go/bigquery"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	defaultVolumeScale = 2
+
+	// Maximum number of decimal places of each BigQuery type
+	maxNumericScale    = 9
+	maxBigNumericScale = 38
+)
+
+var roundingModes = map[string]func(decimal.Decimal, int32) decimal.Decimal{
+	"half_up":   decimal.Decimal.Round,
+	"half_even": decimal.Decimal.RoundBank,
+	"up":        decimal.Decimal.RoundUp,
+	"down":      decimal.Decimal.RoundDown,
+	"ceil":      decimal.Decimal.RoundCeil,
+	"floor":     decimal.Decimal.RoundFloor,
+}
+
+// Describes how exact decimal volumes are rounded and stored in BigQuery
+type numericFormat struct {
+	fieldType bg.FieldType
+	scale     int32
+	round     func(decimal.Decimal, int32) decimal.Decimal
+}
+
+// Defaults to NUMERIC rounded half up to 2 decimal places
+func newNumericFormat(numericType, scale, rounding string) (numericFormat, error) {
+	format := numericFormat{fieldType: bg.NumericFieldType, scale: defaultVolumeScale, round: decimal.Decimal.Round}
+	maxScale := int32(maxNumericScale)
+
+	switch strings.ToUpper(numericType) {
+	case "", "NUMERIC":
+	case "BIGNUMERIC":
+		format.fieldType = bg.BigNumericFieldType
+		maxScale = maxBigNumericScale
+	default:
+		return numericFormat{}, fmt.Errorf("unsupported numeric type: %s", numericType)
+	}
+
+	if scale != "" {
+		parsed, err := strconv.ParseInt(scale, 10, 32)
+		if err != nil || parsed < 0 || int32(parsed) > maxScale {
+			return numericFormat{}, fmt.Errorf("invalid volume scale %q: must be between 0 and %d for %s", scale, maxScale, format.fieldType)
+		}
+		format.scale = int32(parsed)
+	}
+
+	if rounding != "" {
+		round, ok := roundingModes[strings.ToLower(rounding)]
+		if !ok {
+			return numericFormat{}, fmt.Errorf("unsupported rounding mode: %s", rounding)
+		}
+		format.round = round
+	}
+
+	return format, nil
+}
+
+// Rounds the value and formats it as a string, so it can be cast without going through floats
+func (f numericFormat) format(value decimal.Decimal) string {
+	return f.round(value, f.scale).StringFixed(f.scale)
+}
@@ -0,0 +1,125 @@
+package bigquery
+
+import (
+	"bdaggregator/internal/etl"
+	"testing"
+	"time"
+
+	bg "cloud.google.com/go/bigquery"
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewNumericFormat(t *testing.T) {
+	tests := []struct {
+		name          string
+		numericType   string
+		scale         string
+		rounding      string
+		expectedType  bg.FieldType
+		expectedScale int32
+		expectedError string
+	}{
+		{name: "Defaults", expectedType: bg.NumericFieldType, expectedScale: 2},
+		{name: "BigNumeric", numericType: "bignumeric", scale: "18", rounding: "half_even", expectedType: bg.BigNumericFieldType, expectedScale: 18},
+		{name: "Numeric scale limit", numericType: "NUMERIC", scale: "10", expectedError: `invalid volume scale "10": must be between 0 and 9 for NUMERIC`},
+		{name: "Negative scale", scale: "-1", expectedError: `invalid volume scale "-1": must be between 0 and 9 for NUMERIC`},
+		{name: "Unknown type", numericType: "FLOAT64", expectedError: "unsupported numeric type: FLOAT64"},
+		{name: "Unknown rounding", rounding: "nearest", expectedError: "unsupported rounding mode: nearest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format, err := newNumericFormat(tt.numericType, tt.scale, tt.rounding)
+			if tt.expectedError != "" {
+				assert.EqualError(t, err, tt.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expectedType, format.fieldType)
+			assert.Equal(t, tt.expectedScale, format.scale)
+		})
+	}
+}
+
+func TestNumericFormatRounding(t *testing.T) {
+	tests := []struct {
+		rounding string
+		value    string
+		expected string
+	}{
+		{rounding: "half_up", value: "20492271.885", expected: "20492271.89"},
+		{rounding: "half_even", value: "20492271.885", expected: "20492271.88"},
+		{rounding: "half_even", value: "20492271.895", expected: "20492271.90"},
+		{rounding: "up", value: "24.031", expected: "24.04"},
+		{rounding: "down", value: "-24.039", expected: "-24.03"},
+		{rounding: "ceil", value: "-24.039", expected: "-24.03"},
+		{rounding: "floor", value: "-24.031", expected: "-24.04"},
+		{rounding: "half_up", value: "410", expected: "410.00"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.rounding+" "+tt.value, func(t *testing.T) {
+			format, err := newNumericFormat("", "2", tt.rounding)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, format.format(decimal.RequireFromString(tt.value)))
+		})
+	}
+}
+
+func TestAggregationRows(t *testing.T) {
+	format, err := newNumericFormat("BIGNUMERIC", "20", "")
+	assert.NoError(t, err)
+	bq := &BigQueryDB{numeric: format}
+
+	rows := bq.aggregationRows([]etl.AggregatePerProject{
+		{
+			Day:                            "2024-04-01",
+			ProjectID:                      4974,
+			NumberOfTransactionsPerProject: 100,
+			TotalVolumePerProject:          decimal.RequireFromString("20492271.12345678901234567891"),
+			Currency:                       "usd",
+			RateProviders:                  "coingecko,peg",
+			MinRateTimestamp:               1711929600,
+			DistinctRates:                  25,
+			UnpricedEvents:                 2,
+		},
+	})
+
+	assert.Equal(t, []aggregationRow{
+		{
+			Day:                            "2024-04-01",
+			ProjectID:                      4974,
+			NumberOfTransactionsPerProject: 100,
+			TotalVolumePerProject:          "20492271.12345678901234567891",
+			Currency:                       "usd",
+			RateProviders:                  "coingecko,peg",
+			MinRateTimestamp:               bg.NullTimestamp{Timestamp: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC), Valid: true},
+			MaxRateTimestamp:               bg.NullTimestamp{},
+			DistinctRates:                  25,
+			UnpricedEvents:                 2,
+		},
+	}, rows)
+}
+
+func TestRateRows(t *testing.T) {
+	rows := rateRows([]etl.ExchangeRate{
+		{CoinID: "sunflower-land", Currency: "usd", Timestamp: 1711933200, Rate: decimal.RequireFromString("0.0000123456789012345678"), Source: "coingecko"},
+	})
+
+	assert.Equal(t, []rateRow{
+		{
+			CoinID:    "sunflower-land",
+			Currency:  "usd",
+			Timestamp: time.Date(2024, 4, 1, 1, 0, 0, 0, time.UTC),
+			Rate:      "0.0000123456789012345678",
+			Source:    "coingecko",
+		},
+	}, rows, "Expected rates to keep their full precision")
+
+	// An interpolated rate with more decimal places than BIGNUMERIC holds
+	rows = rateRows([]etl.ExchangeRate{
+		{CoinID: "bitcoin", Currency: "usd", Timestamp: 1711933200, Rate: decimal.RequireFromString("0.33333333333333333333333333333333333333333333"), Source: "file"},
+	})
+	assert.Equal(t, "0.33333333333333333333333333333333333333", rows[0].Rate, "Expected the rate rounded to 38 decimal places")
+}
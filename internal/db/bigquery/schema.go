@@ -1,14 +1,95 @@
 package bigquery
 
-import "cloud.google.com/go/bigquery"
+import (
+	"fmt"
+	"strings"
 
-// GetEventSchema returns the BigQuery schema for the 'aggregation' table
-func GetAggregationSchema() bigquery.Schema {
+	"cloud.google.com/go/bigquery"
+)
+
+// GetAggregationSchema returns the BigQuery schema for the 'aggregation' table,
+// volumeType is either NUMERIC or BIGNUMERIC. The provenance columns are nullable,
+// so they can be added to tables created before them.
+func GetAggregationSchema(volumeType bigquery.FieldType) bigquery.Schema {
 	return bigquery.Schema{
 		{Name: "Day", Type: bigquery.DateFieldType, Required: true},
 		{Name: "ProjectID", Type: bigquery.IntegerFieldType, Required: true},
 		{Name: "NumberOfTransactionsPerProject", Type: bigquery.IntegerFieldType, Required: true},
-		{Name: "TotalVolumePerProject", Type: bigquery.NumericFieldType, Required: true},
+		{Name: "TotalVolumePerProject", Type: volumeType, Required: true},
+		{Name: "Currency", Type: bigquery.StringFieldType, Required: true},
+		{Name: "RateProviders", Type: bigquery.StringFieldType, Description: "Comma separated providers of the exchange rates"},
+		{Name: "MinRateTimestamp", Type: bigquery.TimestampFieldType, Description: "Earliest exchange rate used, null for pegged rates only"},
+		{Name: "MaxRateTimestamp", Type: bigquery.TimestampFieldType, Description: "Latest exchange rate used, null for pegged rates only"},
+		{Name: "DistinctRates", Type: bigquery.IntegerFieldType, Description: "Number of distinct exchange rates used"},
+		{Name: "UnpricedEvents", Type: bigquery.IntegerFieldType, Description: "Events without an exchange rate, left out of the volume"},
+	}
+}
+
+// GetRatesSchema returns the BigQuery schema for the 'rates' table, the exchange rates
+// the aggregations were computed from. Rates use BIGNUMERIC as small coins need more
+// than NUMERIC's 9 decimal places.
+func GetRatesSchema() bigquery.Schema {
+	return bigquery.Schema{
+		{Name: "CoinID", Type: bigquery.StringFieldType, Required: true},
+		{Name: "Currency", Type: bigquery.StringFieldType, Required: true},
+		{Name: "Timestamp", Type: bigquery.TimestampFieldType, Required: true},
+		{Name: "Rate", Type: bigquery.BigNumericFieldType, Required: true},
+		{Name: "Source", Type: bigquery.StringFieldType, Required: true},
+	}
+}
+
+// GetEventsSchema returns the BigQuery schema for the optional 'events' table, one row
+// per normalized event and target currency. Rates and volumes are null for unpriced events.
+func GetEventsSchema() bigquery.Schema {
+	return bigquery.Schema{
+		{Name: "Timestamp", Type: bigquery.TimestampFieldType, Required: true},
+		{Name: "ProjectID", Type: bigquery.IntegerFieldType, Required: true},
+		{Name: "Event", Type: bigquery.StringFieldType, Required: true},
+		{Name: "CoinID", Type: bigquery.StringFieldType, Required: true},
+		{Name: "ChainID", Type: bigquery.StringFieldType},
+		{Name: "TxnHash", Type: bigquery.StringFieldType},
+		{Name: "CurrencyValueRaw", Type: bigquery.StringFieldType, Description: "Raw token amount as reported, before applying the decimals"},
+		{Name: "CurrencyValueDecimal", Type: bigquery.BigNumericFieldType, Required: true},
 		{Name: "Currency", Type: bigquery.StringFieldType, Required: true},
+		{Name: "ExchangeRate", Type: bigquery.BigNumericFieldType},
+		{Name: "Volume", Type: bigquery.BigNumericFieldType},
+		{Name: "UnpricedReason", Type: bigquery.StringFieldType},
+		{Name: "RateSource", Type: bigquery.StringFieldType},
+	}
+}
+
+// Fails when a column of the existing table has another type than in the schema, a
+// column can't be converted in place and the writes would fail in the middle of a run
+func checkFieldTypes(tableName string, existing, schema bigquery.Schema) error {
+	types := make(map[string]bigquery.FieldType, len(existing))
+	for _, field := range existing {
+		types[field.Name] = field.Type
+	}
+
+	var mismatches []string
+	for _, field := range schema {
+		if fieldType, exists := types[field.Name]; exists && fieldType != field.Type {
+			mismatches = append(mismatches, fmt.Sprintf("%s is %s, expected %s", field.Name, fieldType, field.Type))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("table %s has columns of another type: %s", tableName, strings.Join(mismatches, ", "))
+	}
+	return nil
+}
+
+// Returns the fields of the schema missing in the existing table's schema
+func missingFields(existing, schema bigquery.Schema) bigquery.Schema {
+	names := make(map[string]bool, len(existing))
+	for _, field := range existing {
+		names[field.Name] = true
+	}
+
+	var missing bigquery.Schema
+	for _, field := range schema {
+		if !names[field.Name] {
+			missing = append(missing, field)
+		}
 	}
+	return missing
 }
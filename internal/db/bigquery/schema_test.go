@@ -13,9 +13,14 @@ func TestGetAggregationSchema(t *testing.T) {
 		{Name: "NumberOfTransactionsPerProject", Type: bigquery.IntegerFieldType, Required: true},
 		{Name: "TotalVolumePerProject", Type: bigquery.NumericFieldType, Required: true},
 		{Name: "Currency", Type: bigquery.StringFieldType, Required: true},
+		{Name: "RateProviders", Type: bigquery.StringFieldType},
+		{Name: "MinRateTimestamp", Type: bigquery.TimestampFieldType},
+		{Name: "MaxRateTimestamp", Type: bigquery.TimestampFieldType},
+		{Name: "DistinctRates", Type: bigquery.IntegerFieldType},
+		{Name: "UnpricedEvents", Type: bigquery.IntegerFieldType},
 	}
 
-	schema := GetAggregationSchema()
+	schema := GetAggregationSchema(bigquery.NumericFieldType)
 
 	if len(schema) != len(expectedSchema) {
 		t.Fatalf("expected schema length %d, got %d", len(expectedSchema), len(schema))
@@ -34,3 +39,71 @@ func TestGetAggregationSchema(t *testing.T) {
 		}
 	}
 }
+
+func TestMissingFields(t *testing.T) {
+	schema := GetAggregationSchema(bigquery.NumericFieldType)
+	existing := schema[:5]
+
+	missing := missingFields(existing, schema)
+	if len(missing) != 5 {
+		t.Fatalf("expected the 5 provenance fields to be missing, got %d", len(missing))
+	}
+	for _, field := range missing {
+		if field.Required {
+			t.Errorf("expected added field %s to be nullable", field.Name)
+		}
+	}
+
+	if missing := missingFields(schema, schema); len(missing) != 0 {
+		t.Errorf("expected no missing fields, got %v", missing)
+	}
+}
+
+func TestCheckFieldTypes(t *testing.T) {
+	existing := GetAggregationSchema(bigquery.NumericFieldType)
+
+	err := checkFieldTypes("aggregation", existing, GetAggregationSchema(bigquery.BigNumericFieldType))
+	if err == nil || err.Error() != "table aggregation has columns of another type: TotalVolumePerProject is NUMERIC, expected BIGNUMERIC" {
+		t.Errorf("expected the mismatched volume column to be reported, got %v", err)
+	}
+
+	if err := checkFieldTypes("aggregation", existing[:5], GetAggregationSchema(bigquery.NumericFieldType)); err != nil {
+		t.Errorf("expected missing columns not to be a type mismatch, got %v", err)
+	}
+}
+
+func TestGetRatesSchema(t *testing.T) {
+	expected := []string{"CoinID", "Currency", "Timestamp", "Rate", "Source"}
+
+	schema := GetRatesSchema()
+	if len(schema) != len(expected) {
+		t.Fatalf("expected schema length %d, got %d", len(expected), len(schema))
+	}
+	for i, field := range schema {
+		if field.Name != expected[i] {
+			t.Errorf("expected field name %s at index %d, got %s", expected[i], i, field.Name)
+		}
+		if !field.Required {
+			t.Errorf("expected field %s to be required", field.Name)
+		}
+	}
+	if schema[3].Type != bigquery.BigNumericFieldType {
+		t.Errorf("expected Rate to be BIGNUMERIC, got %s", schema[3].Type)
+	}
+}
+
+func TestGetEventsSchema(t *testing.T) {
+	required := map[string]bool{
+		"Timestamp": true, "ProjectID": true, "Event": true, "CoinID": true, "CurrencyValueDecimal": true, "Currency": true,
+	}
+
+	schema := GetEventsSchema()
+	if len(schema) != 13 {
+		t.Fatalf("expected schema length 13, got %d", len(schema))
+	}
+	for _, field := range schema {
+		if field.Required != required[field.Name] {
+			t.Errorf("expected field %s to be Required=%t, got Required=%t", field.Name, required[field.Name], field.Required)
+		}
+	}
+}
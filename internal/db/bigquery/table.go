@@ -0,0 +1,140 @@
+package bigquery
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// tableDescriptor describes a table of the sink, its DDL and its MERGE statement are generated from it
+type tableDescriptor struct {
+	name      string
+	schema    bigquery.Schema
+	keys      []string // columns identifying a row, a merged row with the same keys replaces it
+	partition string   // DATE or TIMESTAMP column the table is partitioned by day on, empty for none
+}
+
+// Days, projects and currencies are unique, a rerun updates their totals
+func aggregationTable(volumeType bigquery.FieldType) tableDescriptor {
+	return tableDescriptor{
+		name:   "aggregation",
+		schema: GetAggregationSchema(volumeType),
+		keys:   []string{"Day", "ProjectID", "Currency"},
+	}
+}
+
+func ratesTable() tableDescriptor {
+	return tableDescriptor{
+		name:   "rates",
+		schema: GetRatesSchema(),
+		keys:   []string{"CoinID", "Currency", "Timestamp"},
+	}
+}
+
+// Events have no key, the days of a run are replaced instead of merged
+func eventsTable() tableDescriptor {
+	return tableDescriptor{
+		name:      "events",
+		schema:    GetEventsSchema(),
+		partition: "Timestamp",
+	}
+}
+
+func (t tableDescriptor) metadata() *bigquery.TableMetadata {
+	metadata := &bigquery.TableMetadata{Schema: t.schema}
+	if t.partition != "" {
+		metadata.TimePartitioning = &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: t.partition}
+	}
+	return metadata
+}
+
+// Columns written on insert and update, every column except the keys
+func (t tableDescriptor) values() bigquery.Schema {
+	var values bigquery.Schema
+	for _, field := range t.schema {
+		if !slices.Contains(t.keys, field.Name) {
+			values = append(values, field)
+		}
+	}
+	return values
+}
+
+func (t tableDescriptor) field(name string) *bigquery.FieldSchema {
+	for _, field := range t.schema {
+		if field.Name == name {
+			return field
+		}
+	}
+	return nil
+}
+
+// Generates the MERGE of the rows in @records into the table. The rows are structs
+// with a field per column, named after it.
+func (t tableDescriptor) mergeQuery(table string) (string, error) {
+	if len(t.keys) == 0 {
+		return "", fmt.Errorf("table %s has no key columns to merge on", t.name)
+	}
+
+	conditions := make([]string, 0, len(t.keys))
+	for _, key := range t.keys {
+		field := t.field(key)
+		if field == nil {
+			return "", fmt.Errorf("key column %s is not in the schema of table %s", key, t.name)
+		}
+		conditions = append(conditions, fmt.Sprintf("target.%s = %s", key, sourceValue(field)))
+	}
+
+	var updates []string
+	for _, field := range t.values() {
+		updates = append(updates, fmt.Sprintf("target.%s = %s", field.Name, sourceValue(field)))
+	}
+
+	columns := make([]string, 0, len(t.schema))
+	values := make([]string, 0, len(t.schema))
+	for _, field := range t.schema {
+		columns = append(columns, field.Name)
+		values = append(values, sourceValue(field))
+	}
+
+	var query strings.Builder
+	fmt.Fprintf(&query, "MERGE INTO %s AS target\n", table)
+	query.WriteString("USING UNNEST(@records) AS source\n")
+	fmt.Fprintf(&query, "ON %s\n", strings.Join(conditions, " AND "))
+	if len(updates) > 0 {
+		fmt.Fprintf(&query, "WHEN MATCHED THEN\n  UPDATE SET %s\n", strings.Join(updates, ", "))
+	}
+	fmt.Fprintf(&query, "WHEN NOT MATCHED THEN\n  INSERT (%s)\n  VALUES (%s)", strings.Join(columns, ", "), strings.Join(values, ", "))
+	return query.String(), nil
+}
+
+// Generates the transaction replacing the rows of the days in @days and the currencies in
+// @currencies with the rows of the staging table, either both happen or neither does
+func (t tableDescriptor) replaceQuery(table, staging string) (string, error) {
+	if t.partition == "" {
+		return "", fmt.Errorf("table %s has no partition to replace", t.name)
+	}
+
+	columns := make([]string, 0, len(t.schema))
+	for _, field := range t.schema {
+		columns = append(columns, field.Name)
+	}
+
+	var query strings.Builder
+	query.WriteString("BEGIN TRANSACTION;\n")
+	fmt.Fprintf(&query, "DELETE FROM %s\nWHERE DATE(%s) IN UNNEST(@days) AND Currency IN UNNEST(@currencies);\n", table, t.partition)
+	fmt.Fprintf(&query, "INSERT INTO %s (%s)\nSELECT %s FROM %s;\n", table, strings.Join(columns, ", "), strings.Join(columns, ", "), staging)
+	query.WriteString("COMMIT TRANSACTION;")
+	return query.String(), nil
+}
+
+// Dates and decimals are sent as strings, decimals to keep their precision, and cast by BigQuery
+func sourceValue(field *bigquery.FieldSchema) string {
+	switch field.Type {
+	case bigquery.DateFieldType, bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		return fmt.Sprintf("CAST(source.%s AS %s)", field.Name, field.Type)
+	default:
+		return "source." + field.Name
+	}
+}
@@ -0,0 +1,82 @@
+package bigquery
+
+import (
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableDescriptor_MergeQuery(t *testing.T) {
+	query, err := ratesTable().mergeQuery("`project.dataset.rates`")
+	assert.NoError(t, err)
+	assert.Equal(t, "MERGE INTO `project.dataset.rates` AS target\n"+
+		"USING UNNEST(@records) AS source\n"+
+		"ON target.CoinID = source.CoinID AND target.Currency = source.Currency AND target.Timestamp = source.Timestamp\n"+
+		"WHEN MATCHED THEN\n"+
+		"  UPDATE SET target.Rate = CAST(source.Rate AS BIGNUMERIC), target.Source = source.Source\n"+
+		"WHEN NOT MATCHED THEN\n"+
+		"  INSERT (CoinID, Currency, Timestamp, Rate, Source)\n"+
+		"  VALUES (source.CoinID, source.Currency, source.Timestamp, CAST(source.Rate AS BIGNUMERIC), source.Source)", query)
+
+	query, err = aggregationTable(bigquery.NumericFieldType).mergeQuery("`project.dataset.aggregation`")
+	assert.NoError(t, err)
+	assert.Contains(t, query, "ON target.Day = CAST(source.Day AS DATE) AND target.ProjectID = source.ProjectID AND target.Currency = source.Currency\n")
+	assert.Contains(t, query, "target.TotalVolumePerProject = CAST(source.TotalVolumePerProject AS NUMERIC)")
+	assert.NotContains(t, query, "target.Currency = source.Currency,", "Expected key columns not to be updated")
+
+	_, err = eventsTable().mergeQuery("`project.dataset.events`")
+	assert.EqualError(t, err, "table events has no key columns to merge on")
+
+	_, err = tableDescriptor{name: "broken", schema: GetRatesSchema(), keys: []string{"Day"}}.mergeQuery("`broken`")
+	assert.EqualError(t, err, "key column Day is not in the schema of table broken")
+}
+
+func TestTableDescriptor_ReplaceQuery(t *testing.T) {
+	query, err := eventsTable().replaceQuery("`project.dataset.events`", "`project.dataset.events_staging_1`")
+	assert.NoError(t, err)
+	assert.Equal(t, "BEGIN TRANSACTION;\n"+
+		"DELETE FROM `project.dataset.events`\n"+
+		"WHERE DATE(Timestamp) IN UNNEST(@days) AND Currency IN UNNEST(@currencies);\n"+
+		"INSERT INTO `project.dataset.events` (Timestamp, ProjectID, Event, CoinID, ChainID, TxnHash, CurrencyValueRaw, CurrencyValueDecimal, Currency, ExchangeRate, Volume, UnpricedReason, RateSource)\n"+
+		"SELECT Timestamp, ProjectID, Event, CoinID, ChainID, TxnHash, CurrencyValueRaw, CurrencyValueDecimal, Currency, ExchangeRate, Volume, UnpricedReason, RateSource FROM `project.dataset.events_staging_1`;\n"+
+		"COMMIT TRANSACTION;", query)
+
+	_, err = ratesTable().replaceQuery("`project.dataset.rates`", "`staging`")
+	assert.EqualError(t, err, "table rates has no partition to replace")
+}
+
+func TestTableDescriptor_Metadata(t *testing.T) {
+	metadata := eventsTable().metadata()
+	assert.Equal(t, GetEventsSchema(), metadata.Schema)
+	assert.Equal(t, &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "Timestamp"}, metadata.TimePartitioning)
+
+	assert.Nil(t, ratesTable().metadata().TimePartitioning)
+}
+
+// The rows sent to BigQuery have to name every column of their table
+func TestRowsMatchSchema(t *testing.T) {
+	tests := []struct {
+		table tableDescriptor
+		row   interface{}
+	}{
+		{aggregationTable(bigquery.NumericFieldType), aggregationRow{}},
+		{ratesTable(), rateRow{}},
+		{eventsTable(), eventRow{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.table.name, func(t *testing.T) {
+			var columns, fields []string
+			for _, field := range tt.table.schema {
+				columns = append(columns, field.Name)
+			}
+			rowType := reflect.TypeOf(tt.row)
+			for i := 0; i < rowType.NumField(); i++ {
+				fields = append(fields, rowType.Field(i).Name)
+			}
+			assert.Equal(t, columns, fields)
+		})
+	}
+}
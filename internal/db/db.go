@@ -1,10 +1,18 @@
 package db
 
-import "context"
+import (
+	"bdaggregator/internal/etl"
+	"context"
+)
 
+// Database is the sink of a run, each table is written by its own typed method
 type Database interface {
 	SetupDatabase(ctx context.Context) error
+	// Creates the table ("aggregation", "rates" or "events") or adds its new columns
 	SetupTable(ctx context.Context, tableName string) error
-	Upsert(ctx context.Context, tableName string, records interface{}) error
+	UpsertAggregations(ctx context.Context, aggregates []etl.AggregatePerProject) error
+	UpsertRates(ctx context.Context, rates []etl.ExchangeRate) error
+	// Replaces the stored events of the days and currencies of the events
+	ReplaceEvents(ctx context.Context, events []etl.EventRecord) error
 	Close() error
 }
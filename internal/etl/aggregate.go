@@ -1,6 +1,8 @@
 package etl
 
 import (
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/shopspring/decimal"
@@ -10,8 +12,29 @@ type AggregatePerProject struct {
 	Day                            string
 	ProjectID                      int
 	NumberOfTransactionsPerProject int
-	TotalVolumePerProject          float64
+	TotalVolumePerProject          decimal.Decimal
 	Currency                       string
+
+	// Provenance of the volume
+	RateProviders    string // sorted and comma separated, e.g. "coingecko,peg"
+	MinRateTimestamp int64  // Unix seconds of the earliest rate used, 0 without timestamped rates
+	MaxRateTimestamp int64  // Unix seconds of the latest rate used
+	DistinctRates    int    // rates of distinct coins or timestamps the volume was computed from
+	UnpricedEvents   int    // events counted as transactions but left out of the volume
+
+	provenance *rateProvenance
+}
+
+// Sets of the sources and rates used by an aggregate, summarized when the aggregation is done
+type rateProvenance struct {
+	sources map[string]bool
+	rates   map[ratePoint]bool
+}
+
+// A rate is identified by its coin and timestamp, a pegged rate has no timestamp
+type ratePoint struct {
+	coinID    string
+	timestamp int64
 }
 
 // Aggregates events by day and project, calculating total volume in the specified currency.
@@ -23,11 +46,8 @@ func AggregateEvents(events []Event, defaultCurrency string) []AggregatePerProje
 	wg := sync.WaitGroup{}
 
 	for i := 0; i < numWorkers; i++ {
-		start := i * chunkSize
-		end := start + chunkSize
-		if end > len(events) {
-			end = len(events)
-		}
+		start := min(i*chunkSize, len(events))
+		end := min(start+chunkSize, len(events))
 
 		wg.Add(1)
 		go func(eventsChunk []Event) {
@@ -46,7 +66,10 @@ func aggregateChunk(eventsChunk []Event, defaultCurrency string) map[string]map[
 	chunkAggregate := make(map[string]map[int]*AggregatePerProject)
 	for _, event := range eventsChunk {
 		day := event.Ts.Format("2006-01-02")
-		volume := calculateVolume(event)
+		volume := decimal.Zero
+		if !event.Unpriced {
+			volume = calculateVolume(event)
+		}
 
 		if _, exists := chunkAggregate[day]; !exists {
 			chunkAggregate[day] = make(map[int]*AggregatePerProject)
@@ -54,16 +77,14 @@ func aggregateChunk(eventsChunk []Event, defaultCurrency string) map[string]map[
 
 		initializeAggregateEntry(chunkAggregate[day], event.ProjectID, day, defaultCurrency)
 		updateAggregateEntry(chunkAggregate[day][event.ProjectID], volume)
+		recordProvenance(chunkAggregate[day][event.ProjectID], event)
 	}
 	return chunkAggregate
 }
 
-func calculateVolume(event Event) float64 {
-	currencyValue := event.CurrencyValueDecimal
-	if event.CoinID == "matic-network" {
-		currencyValue = currencyValue.Div(decimal.NewFromInt(1e18))
-	}
-	return currencyValue.Mul(event.CurrencyExchangeRate).InexactFloat64()
+// CurrencyValueDecimal is already in token units, raw amounts are converted while parsing
+func calculateVolume(event Event) decimal.Decimal {
+	return event.CurrencyValueDecimal.Mul(event.CurrencyExchangeRate)
 }
 
 func initializeAggregateEntry(projectData map[int]*AggregatePerProject, projectID int, day, defaultCurrency string) {
@@ -72,15 +93,40 @@ func initializeAggregateEntry(projectData map[int]*AggregatePerProject, projectI
 			Day:                            day,
 			ProjectID:                      projectID,
 			NumberOfTransactionsPerProject: 0,
-			TotalVolumePerProject:          0,
+			TotalVolumePerProject:          decimal.Zero,
 			Currency:                       defaultCurrency,
+			provenance:                     &rateProvenance{sources: make(map[string]bool), rates: make(map[ratePoint]bool)},
 		}
 	}
 }
 
-func updateAggregateEntry(entry *AggregatePerProject, volume float64) {
+func updateAggregateEntry(entry *AggregatePerProject, volume decimal.Decimal) {
 	entry.NumberOfTransactionsPerProject++
-	entry.TotalVolumePerProject += volume
+	entry.TotalVolumePerProject = entry.TotalVolumePerProject.Add(volume)
+}
+
+// Records the source and the rates an event was priced with
+func recordProvenance(entry *AggregatePerProject, event Event) {
+	if event.Unpriced {
+		entry.UnpricedEvents++
+		return
+	}
+
+	if event.RateSource != "" {
+		entry.provenance.sources[event.RateSource] = true
+	}
+	entry.provenance.rates[ratePoint{coinID: event.CoinID, timestamp: event.RateFrom}] = true
+	entry.provenance.rates[ratePoint{coinID: event.CoinID, timestamp: event.RateTo}] = true
+
+	if event.RateFrom == 0 {
+		return
+	}
+	if entry.MinRateTimestamp == 0 || event.RateFrom < entry.MinRateTimestamp {
+		entry.MinRateTimestamp = event.RateFrom
+	}
+	if event.RateTo > entry.MaxRateTimestamp {
+		entry.MaxRateTimestamp = event.RateTo
+	}
 }
 
 // Merge a chunk’s data into the main aggregation map.
@@ -109,7 +155,18 @@ func mergeAggregateEntry(dayData map[int]*AggregatePerProject, projectID int, ag
 		dayData[projectID] = aggregate
 	} else {
 		existing.NumberOfTransactionsPerProject += aggregate.NumberOfTransactionsPerProject
-		existing.TotalVolumePerProject += aggregate.TotalVolumePerProject
+		existing.TotalVolumePerProject = existing.TotalVolumePerProject.Add(aggregate.TotalVolumePerProject)
+		existing.UnpricedEvents += aggregate.UnpricedEvents
+		if aggregate.MinRateTimestamp != 0 && (existing.MinRateTimestamp == 0 || aggregate.MinRateTimestamp < existing.MinRateTimestamp) {
+			existing.MinRateTimestamp = aggregate.MinRateTimestamp
+		}
+		existing.MaxRateTimestamp = max(existing.MaxRateTimestamp, aggregate.MaxRateTimestamp)
+		for source := range aggregate.provenance.sources {
+			existing.provenance.sources[source] = true
+		}
+		for rate := range aggregate.provenance.rates {
+			existing.provenance.rates[rate] = true
+		}
 	}
 }
 
@@ -117,11 +174,23 @@ func convertToSlice(aggregatedData map[string]map[int]*AggregatePerProject, defa
 	var result []AggregatePerProject
 	for _, projectData := range aggregatedData {
 		for _, aggregate := range projectData {
-			// Round to 2 decimal places for BigQuery compatibility
-			aggregate.TotalVolumePerProject = decimal.NewFromFloat(aggregate.TotalVolumePerProject).Round(2).InexactFloat64()
+			// Volumes are kept exact, rounding is applied by the database when writing
 			aggregate.Currency = defaultCurrency // Set to specified currency
+			aggregate.summarizeProvenance()
 			result = append(result, *aggregate)
 		}
 	}
 	return result
 }
+
+func (a *AggregatePerProject) summarizeProvenance() {
+	sources := make([]string, 0, len(a.provenance.sources))
+	for source := range a.provenance.sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	a.RateProviders = strings.Join(sources, ",")
+	a.DistinctRates = len(a.provenance.rates)
+	a.provenance = nil
+}
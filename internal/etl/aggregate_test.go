@@ -1,6 +1,7 @@
 package etl
 
 import (
+	"fmt"
 	"testing"
 	"time"
 
@@ -16,7 +17,7 @@ func TestAggregateEvents(t *testing.T) {
 			ProjectID:            1,
 			CoinID:               "matic-network",
 			CurrencyExchangeRate: decimal.NewFromFloat(1.5),
-			CurrencyValueDecimal: decimal.NewFromInt(1000000000000000000), // 1 MATIC
+			CurrencyValueDecimal: decimal.NewFromInt(1), // 1 MATIC
 		},
 		{
 			Ts:                   time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC),
@@ -39,29 +40,88 @@ func TestAggregateEvents(t *testing.T) {
 			Day:                            "2024-04-15",
 			ProjectID:                      1,
 			NumberOfTransactionsPerProject: 2,
-			TotalVolumePerProject:          201.5,
+			TotalVolumePerProject:          decimal.RequireFromString("201.5"),
 			Currency:                       defaultCurrency,
 		},
 		{
 			Day:                            "2024-04-16",
 			ProjectID:                      2,
 			NumberOfTransactionsPerProject: 1,
-			TotalVolumePerProject:          50.0,
+			TotalVolumePerProject:          decimal.RequireFromString("50"),
 			Currency:                       defaultCurrency,
 		},
 	}
 
 	aggregated := AggregateEvents(events, defaultCurrency)
 
-	assert.ElementsMatch(t, expected, aggregated)
+	assert.ElementsMatch(t, aggregateStrings(expected), aggregateStrings(aggregated))
+}
+
+func TestAggregateEventsKeepsExactVolume(t *testing.T) {
+	events := make([]Event, 0, 10)
+	for i := 0; i < 10; i++ {
+		events = append(events, Event{
+			Ts:                   time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC),
+			ProjectID:            4974,
+			CurrencyExchangeRate: decimal.RequireFromString("0.1"),
+			CurrencyValueDecimal: decimal.RequireFromString("2049227.189"),
+		})
+	}
+
+	aggregated := AggregateEvents(events, "usd")
+
+	assert.Len(t, aggregated, 1)
+	assert.Equal(t, "2049227.189", aggregated[0].TotalVolumePerProject.String())
+	assert.Equal(t, 10, aggregated[0].NumberOfTransactionsPerProject)
+}
+
+func TestAggregateEventsRecordsProvenance(t *testing.T) {
+	day := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	priced := func(coinID, source string, rateFrom, rateTo int64) Event {
+		return Event{Ts: day, ProjectID: 1, CoinID: coinID, CurrencyExchangeRate: decimal.NewFromInt(1), CurrencyValueDecimal: decimal.NewFromInt(1),
+			RateSource: source, RateFrom: rateFrom, RateTo: rateTo}
+	}
+	ts := day.Unix()
+
+	// Spread over several chunks so the provenance is merged between workers
+	events := []Event{
+		priced("matic-network", "coingecko", ts+3600, ts+3600),
+		priced("matic-network", "coingecko", ts+3600, ts+3600),
+		priced("matic-network", "coingecko", ts, ts+7200),
+		priced("usd-coin", "peg", 0, 0),
+		priced("usd-coin", "peg", 0, 0),
+		{Ts: day, ProjectID: 1, CoinID: "unknown-coin", Unpriced: true, UnpricedReason: UnpricedNoRates},
+		{Ts: day, ProjectID: 1, CoinID: "matic-network", Unpriced: true, UnpricedReason: UnpricedStaleRate},
+		priced("sunflower-land", "file", ts-600, ts-600),
+	}
+
+	aggregated := AggregateEvents(events, "usd")
+
+	if assert.Len(t, aggregated, 1) {
+		assert.Equal(t, "coingecko,file,peg", aggregated[0].RateProviders)
+		assert.Equal(t, ts-600, aggregated[0].MinRateTimestamp)
+		assert.Equal(t, ts+7200, aggregated[0].MaxRateTimestamp)
+		assert.Equal(t, 5, aggregated[0].DistinctRates, "Expected 3 matic-network rates, the usd-coin peg and 1 sunflower-land rate")
+		assert.Equal(t, 2, aggregated[0].UnpricedEvents)
+		assert.Equal(t, 8, aggregated[0].NumberOfTransactionsPerProject)
+	}
+}
+
+// decimal.Decimal values are compared by their string form, the internal representation may differ
+func aggregateStrings(aggregates []AggregatePerProject) []string {
+	result := make([]string, 0, len(aggregates))
+	for _, aggregate := range aggregates {
+		result = append(result, fmt.Sprintf("%s|%d|%d|%s|%s", aggregate.Day, aggregate.ProjectID, aggregate.NumberOfTransactionsPerProject, aggregate.TotalVolumePerProject.String(), aggregate.Currency))
+	}
+	return result
 }
 
 func TestCalculateVolume(t *testing.T) {
 	event := Event{
 		CoinID:               "matic-network",
 		CurrencyExchangeRate: decimal.NewFromFloat(1.5),
-		CurrencyValueDecimal: decimal.NewFromInt(1000000000000000000), // 1 MATIC
+		CurrencyValueDecimal: decimal.NewFromInt(1), // 1 MATIC, raw wei amounts are converted while parsing
 	}
 	volume := calculateVolume(event)
-	assert.Equal(t, 1.5, volume)
+	assert.Equal(t, "1.5", volume.String())
 }
@@ -0,0 +1,64 @@
+package etl
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Column names read from the export header
+const (
+	ColumnTs        = "ts"
+	ColumnEvent     = "event"
+	ColumnProjectID = "project_id"
+	ColumnProps     = "props"
+	ColumnNums      = "nums"
+)
+
+// Columns that must be present in every object to build an Event
+var RequiredColumns = []string{ColumnTs, ColumnEvent, ColumnProjectID, ColumnProps, ColumnNums}
+
+// Maps a column name to its position in a row
+type ColumnMap map[string]int
+
+// A single data row along with the object it was read from and its column layout
+type Row struct {
+	Object  string
+	Values  []string
+	Columns ColumnMap
+}
+
+// Build a column map from a header row and validate that all required columns exist
+func NewColumnMap(header []string) (ColumnMap, error) {
+	columns := make(ColumnMap, len(header))
+	for i, name := range header {
+		// Exports saved by spreadsheet tools may start with a UTF-8 byte order mark
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		if _, exists := columns[name]; !exists {
+			columns[name] = i
+		}
+	}
+
+	var missing []string
+	for _, name := range RequiredColumns {
+		if _, exists := columns[name]; !exists {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("missing required columns: %s (header: %s)", strings.Join(missing, ", "), strings.Join(header, ", "))
+	}
+
+	return columns, nil
+}
+
+// Return the value of a named column, failing when the row is too short to contain it
+func (r Row) Value(name string) (string, error) {
+	i, exists := r.Columns[name]
+	if !exists {
+		return "", fmt.Errorf("unknown column %s", name)
+	}
+	if i >= len(r.Values) {
+		return "", fmt.Errorf("row has %d fields, column %s is at position %d", len(r.Values), name, i+1)
+	}
+	return r.Values[i], nil
+}
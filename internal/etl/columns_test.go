@@ -0,0 +1,41 @@
+package etl
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewColumnMap(t *testing.T) {
+	header := strings.Split("\ufeffapp,ts,event,project_id,source,props,nums", ",")
+
+	columns, err := NewColumnMap(header)
+
+	assert.NoError(t, err, "Expected no error for a header with all required columns")
+	assert.Equal(t, 1, columns[ColumnTs])
+	assert.Equal(t, 3, columns[ColumnProjectID])
+	assert.Equal(t, 6, columns[ColumnNums])
+	assert.Equal(t, 0, columns["app"], "Expected byte order mark to be stripped")
+}
+
+func TestNewColumnMap_MissingColumns(t *testing.T) {
+	_, err := NewColumnMap([]string{"app", "ts", "event", "props"})
+
+	assert.Error(t, err, "Expected an error for missing required columns")
+	assert.Contains(t, err.Error(), "missing required columns: project_id, nums")
+}
+
+func TestRowValue(t *testing.T) {
+	row := Row{
+		Values:  []string{"2024-04-15 02:15:07.167", "BUY_ITEMS"},
+		Columns: ColumnMap{ColumnTs: 0, ColumnEvent: 1, ColumnNums: 4},
+	}
+
+	value, err := row.Value(ColumnEvent)
+	assert.NoError(t, err)
+	assert.Equal(t, "BUY_ITEMS", value)
+
+	_, err = row.Value(ColumnNums)
+	assert.Error(t, err, "Expected an error for a row shorter than the header")
+}
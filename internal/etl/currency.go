@@ -1,16 +1,186 @@
 package etl
 
 import (
+	"bdaggregator/internal/currency"
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"strconv"
+	"runtime"
+	"slices"
+	"sort"
+	"strings"
 	"sync"
 
 	"github.com/shopspring/decimal"
 )
 
-// Holds exchange rates mapped by coin ID and timestamp
-type FetchedExchangeRates map[string]map[int64]decimal.Decimal
+// Holds the exchange rate series of each coin ID
+type FetchedExchangeRates map[string]RateSeries
+
+// RateSeries holds the rates of a coin sorted by timestamp, so the rate
+// of an event is found with a binary search
+type RateSeries struct {
+	timestamps []int64
+	rates      []decimal.Decimal
+	volumes    []decimal.Decimal // traded with each rate, nil when the provider has no volumes
+	pegged     bool              // a constant rate, valid at any distance from the events
+	source     string            // the provider that served the rates
+}
+
+func NewRateSeries(rates map[int64]decimal.Decimal) RateSeries {
+	series := RateSeries{
+		timestamps: make([]int64, 0, len(rates)),
+		rates:      make([]decimal.Decimal, 0, len(rates)),
+	}
+	for timestamp := range rates {
+		series.timestamps = append(series.timestamps, timestamp)
+	}
+	slices.Sort(series.timestamps)
+	for _, timestamp := range series.timestamps {
+		series.rates = append(series.rates, rates[timestamp])
+	}
+	return series
+}
+
+// Keeps the volume reported with each rate, a rate without one weighs nothing
+func NewRateSeriesWithVolumes(rates, volumes map[int64]decimal.Decimal) RateSeries {
+	series := NewRateSeries(rates)
+	if volumes == nil {
+		return series
+	}
+	series.volumes = make([]decimal.Decimal, len(series.timestamps))
+	for i, timestamp := range series.timestamps {
+		series.volumes[i] = volumes[timestamp]
+	}
+	return series
+}
+
+func newPeggedRateSeries(rate decimal.Decimal, from, to int64) RateSeries {
+	series := NewRateSeries(map[int64]decimal.Decimal{from: rate, to: rate})
+	series.pegged = true
+	series.source = "peg"
+	return series
+}
+
+func (s RateSeries) Len() int {
+	return len(s.timestamps)
+}
+
+// Returns the i-th rate in time order
+func (s RateSeries) At(i int) (int64, decimal.Decimal) {
+	return s.timestamps[i], s.rates[i]
+}
+
+// Returns the rate stamped exactly at the timestamp
+func (s RateSeries) Rate(timestamp int64) (decimal.Decimal, bool) {
+	i, found := slices.BinarySearch(s.timestamps, timestamp)
+	if !found {
+		return decimal.Zero, false
+	}
+	return s.rates[i], true
+}
+
+// Finds the latest rate at or before the timestamp and the earliest one at or after it
+func (s RateSeries) surrounding(tsUnix int64) (prevTs int64, prevRate decimal.Decimal, hasPrev bool, nextTs int64, nextRate decimal.Decimal, hasNext bool) {
+	i, found := slices.BinarySearch(s.timestamps, tsUnix)
+	if found {
+		return tsUnix, s.rates[i], true, tsUnix, s.rates[i], true
+	}
+	if i > 0 {
+		prevTs, prevRate, hasPrev = s.timestamps[i-1], s.rates[i-1], true
+	}
+	if i < len(s.timestamps) {
+		nextTs, nextRate, hasNext = s.timestamps[i], s.rates[i], true
+	}
+	return
+}
+
+// Parses the comma separated SEQUENCE_TARGET_CURRENCIES, falls back to SEQUENCE_DEFAULT_CURRENCY.
+// Currencies keep the listed order and case, as they are written to the Currency column,
+// and are compared case-insensitively to drop duplicates.
+func ParseTargetCurrencies(targetCurrencies, defaultCurrency string) ([]string, error) {
+	if strings.TrimSpace(targetCurrencies) == "" {
+		targetCurrencies = defaultCurrency
+	}
+
+	var currencies []string
+	for _, targetCurrency := range strings.Split(targetCurrencies, ",") {
+		targetCurrency = strings.TrimSpace(targetCurrency)
+		duplicate := slices.ContainsFunc(currencies, func(listed string) bool {
+			return strings.EqualFold(listed, targetCurrency)
+		})
+		if targetCurrency != "" && !duplicate {
+			currencies = append(currencies, targetCurrency)
+		}
+	}
+	if len(currencies) == 0 {
+		return nil, errors.New("no target currency configured")
+	}
+	return currencies, nil
+}
+
+// ExchangeRate is a single rate of a coin as fetched from its provider
+type ExchangeRate struct {
+	CoinID    string
+	Currency  string
+	Timestamp int64
+	Rate      decimal.Decimal
+	Source    string
+}
+
+// Flattens the fetched series into rates sorted by coin and timestamp
+func (r FetchedExchangeRates) Records(targetCurrency string) []ExchangeRate {
+	coinIDs := make([]string, 0, len(r))
+	total := 0
+	for coinID, series := range r {
+		coinIDs = append(coinIDs, coinID)
+		total += series.Len()
+	}
+	sort.Strings(coinIDs)
+
+	records := make([]ExchangeRate, 0, total)
+	for _, coinID := range coinIDs {
+		series := r[coinID]
+		for i := 0; i < series.Len(); i++ {
+			timestamp, rate := series.At(i)
+			records = append(records, ExchangeRate{CoinID: coinID, Currency: targetCurrency, Timestamp: timestamp, Rate: rate, Source: series.source})
+		}
+	}
+	return records
+}
+
+// Converts the series the run priced with into snapshot series for offline reruns. A series
+// covers the range it was fetched for, as its provider answered for all of it, a coin without
+// rates is exported without any so an offline run leaves it unpriced too. Pegged series
+// are left out, offline runs price them from their peg without a provider.
+func (r FetchedExchangeRates) SnapshotSeries(targetCurrency string, fetchRanges CurrencyUsageMap) []currency.RateSnapshotSeries {
+	var snapshotSeries []currency.RateSnapshotSeries
+	for coinID, series := range r {
+		fetchRange, fetched := fetchRanges[coinID]
+		if series.pegged || !fetched {
+			continue
+		}
+
+		entry := currency.RateSnapshotSeries{
+			CoinID:   coinID,
+			Currency: strings.ToLower(targetCurrency),
+			Coverage: []currency.TimeRange{{From: fetchRange.From, To: fetchRange.To}},
+			Rates:    make(map[int64]decimal.Decimal, series.Len()),
+		}
+		if series.volumes != nil {
+			entry.Volumes = make(map[int64]decimal.Decimal, series.Len())
+		}
+		for i, timestamp := range series.timestamps {
+			entry.Rates[timestamp] = series.rates[i]
+			if series.volumes != nil {
+				entry.Volumes[timestamp] = series.volumes[i]
+			}
+		}
+		snapshotSeries = append(snapshotSeries, entry)
+	}
+	return snapshotSeries
+}
 
 // Stores information about each currency symbol, the earliest and latest Unix timestamps
 type CurrencyUsage struct {
@@ -40,74 +210,128 @@ func UpdateCurrencyUsageMap(currencyUsageMap CurrencyUsageMap, mu *sync.Mutex, c
 	currencyUsageMap[coinID] = usage
 }
 
-// Retrieve exchange rates for each currency in the usage map, using a provided fetch function.
-func GetExchangeRates(currencyUsageMap CurrencyUsageMap, targetCurrency string, fetchFunc func(string, string, string, string) (map[int64]decimal.Decimal, error)) (FetchedExchangeRates, error) {
+// Retrieve exchange rates for each currency in the usage map from the rate provider,
+// with the traded volumes when withVolumes is set and the provider serves them.
+// Pegged assets are priced at their peg without calling the provider, in the check
+// mode their rates are fetched too and the days off the peg are returned.
+// The failures of all coins are returned together, sorted by coin.
+func GetExchangeRates(ctx context.Context, currencyUsageMap CurrencyUsageMap, targetCurrency string, provider currency.RateProvider, pegs PegPolicy, withVolumes bool) (FetchedExchangeRates, PegDeviations, error) {
 	allExchangeRates := make(FetchedExchangeRates)
+	var deviations PegDeviations
 	var wg sync.WaitGroup
 	mu := &sync.Mutex{}
-	errChan := make(chan error, len(currencyUsageMap))
+	var errs []error
 
 	for coinID, timeRange := range currencyUsageMap {
+		if peg, pegged := pegs.rate(coinID, targetCurrency); pegged {
+			// Fetches started earlier in the loop write to the map concurrently
+			mu.Lock()
+			allExchangeRates[coinID] = newPeggedRateSeries(peg, timeRange.From, timeRange.To)
+			mu.Unlock()
+			if pegs.Mode != PegModeCheck {
+				continue
+			}
+
+			wg.Add(1)
+			go func(coinID string, timeRange CurrencyUsage) {
+				defer wg.Done()
+				observed, err := provider.FetchRates(ctx, coinID, targetCurrency, timeRange.From, timeRange.To)
+				if err != nil {
+					// The events are priced at the peg either way
+					log.Printf("Skipping the peg check of %s: %v", coinID, err)
+					return
+				}
+
+				coinDeviations := checkPeg(coinID, targetCurrency, peg, observed, pegs.Tolerance)
+				mu.Lock()
+				deviations = append(deviations, coinDeviations...)
+				mu.Unlock()
+			}(coinID, timeRange)
+			continue
+		}
+
 		wg.Add(1)
 		go func(coinID string, timeRange CurrencyUsage) {
 			defer wg.Done()
-			from := strconv.FormatInt(timeRange.From, 10)
-			to := strconv.FormatInt(timeRange.To, 10)
-
-			exchangeRates, err := fetchFunc(coinID, targetCurrency, from, to)
+			var exchangeRates, volumes map[int64]decimal.Decimal
+			var err error
+			if withVolumes {
+				exchangeRates, volumes, err = currency.FetchRatesWithVolumes(ctx, provider, coinID, targetCurrency, timeRange.From, timeRange.To)
+			} else {
+				exchangeRates, err = provider.FetchRates(ctx, coinID, targetCurrency, timeRange.From, timeRange.To)
+			}
+			if errors.Is(err, currency.ErrRatesNotFound) {
+				// Events of the coin are left unpriced and reported, the empty series
+				// records that the providers had no rates for the range
+				log.Printf("No exchange rates found for %s: %v", coinID, err)
+				mu.Lock()
+				allExchangeRates[coinID] = RateSeries{}
+				mu.Unlock()
+				return
+			}
 			if err != nil {
-				errChan <- fmt.Errorf("failed to get exchange rates for %s: %w", coinID, err)
+				mu.Lock()
+				errs = append(errs, fmt.Errorf("failed to get exchange rates for %s: %w", coinID, err))
+				mu.Unlock()
 				return
 			}
 
+			series := NewRateSeriesWithVolumes(exchangeRates, volumes)
+			series.source = currency.RateSource(provider, coinID, targetCurrency)
 			mu.Lock()
-			allExchangeRates[coinID] = exchangeRates
+			allExchangeRates[coinID] = series
 			mu.Unlock()
 		}(coinID, timeRange)
 	}
 
 	wg.Wait()
-	close(errChan)
-	if len(errChan) > 0 {
-		return nil, <-errChan
+	if len(errs) > 0 {
+		sort.Slice(errs, func(i, j int) bool { return errs[i].Error() < errs[j].Error() })
+		return nil, nil, errors.Join(errs...)
 	}
 
-	return allExchangeRates, nil
+	deviations.sort()
+	return allExchangeRates, deviations, nil
 }
 
-// Update events with the closest exchange rate
-func UpdateExchangeRates(events []Event, exchangeRates FetchedExchangeRates) {
+// Update events with the exchange rate picked by the matching strategy, events
+// without a rate close enough are marked as unpriced and counted in the report.
+// Events are split into one contiguous chunk per CPU.
+func UpdateExchangeRates(events []Event, exchangeRates FetchedExchangeRates, matching RateMatching) *PricingReport {
+	numWorkers := runtime.NumCPU()
+	chunkSize := (len(events) + numWorkers - 1) / numWorkers
+	reports := make([]*PricingReport, numWorkers)
 	var wg sync.WaitGroup
-	for i := range events {
+
+	for worker := 0; worker < numWorkers; worker++ {
+		start := min(worker*chunkSize, len(events))
+		end := min(start+chunkSize, len(events))
+		reports[worker] = NewPricingReport()
+
 		wg.Add(1)
-		go func(i int) {
+		go func(chunk []Event, report *PricingReport) {
 			defer wg.Done()
-			closestRate := findClosestExchangeRate(events[i].CoinID, events[i].TsUnix, exchangeRates)
-			events[i].CurrencyExchangeRate = closestRate
-		}(i)
+			for i := range chunk {
+				series := exchangeRates[chunk[i].CoinID]
+				rate, rateFrom, rateTo, reason := matching.match(series, chunk[i].TsUnix)
+				chunk[i].CurrencyExchangeRate = rate
+				chunk[i].RateSource, chunk[i].RateFrom, chunk[i].RateTo = "", 0, 0
+				if reason == "" {
+					chunk[i].RateSource, chunk[i].RateFrom, chunk[i].RateTo = series.source, rateFrom, rateTo
+				}
+				chunk[i].Unpriced = reason != ""
+				chunk[i].UnpricedReason = reason
+				report.record(chunk[i])
+			}
+		}(events[start:end], reports[worker])
 	}
 	wg.Wait()
-}
 
-// Find the closest exchange rate in time for a given coin ID and timestamp
-func findClosestExchangeRate(coinID string, tsUnix int64, exchangeRates FetchedExchangeRates) decimal.Decimal {
-	rates, exists := exchangeRates[coinID]
-	if !exists {
-		log.Println("No exchange rates found for coin ID:", coinID)
-		return decimal.Zero
+	report := NewPricingReport()
+	for _, workerReport := range reports {
+		report.merge(workerReport)
 	}
-
-	var closestRate decimal.Decimal
-	var minDiff int64 = 1<<63 - 1 // max int64 value
-
-	for rateTs, rate := range rates {
-		diff := abs(tsUnix - rateTs)
-		if diff < minDiff {
-			minDiff = diff
-			closestRate = rate
-		}
-	}
-	return closestRate
+	return report
 }
 
 func PrintCurrencyUsage(currencyUsageMap CurrencyUsageMap) {
@@ -115,10 +339,3 @@ func PrintCurrencyUsage(currencyUsageMap CurrencyUsageMap) {
 		log.Printf("Currency: %s, From: %d, To: %d", coinID, usage.From, usage.To)
 	}
 }
-
-func abs(x int64) int64 {
-	if x < 0 {
-		return -x
-	}
-	return x
-}
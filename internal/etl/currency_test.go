@@ -1,15 +1,25 @@
 package etl
 
 import (
+	"bdaggregator/internal/currency"
+	"context"
 	"fmt"
+	"math/rand"
 	"testing"
+	"time"
 
 	"github.com/shopspring/decimal"
 	"github.com/stretchr/testify/assert"
 )
 
-// Mock function to replace currency.FetchExchangeRates for testing
-func mockFetchExchangeRates(coinID, targetCurrency, from, to string) (map[int64]decimal.Decimal, error) {
+// Mock provider to replace CoinGecko for testing
+type mockRateProvider struct{}
+
+func (mockRateProvider) Name() string {
+	return "mock"
+}
+
+func (mockRateProvider) FetchRates(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error) {
 	switch coinID {
 	case "bitcoin":
 		return map[int64]decimal.Decimal{
@@ -21,6 +31,8 @@ func mockFetchExchangeRates(coinID, targetCurrency, from, to string) (map[int64]
 			1609459200: decimal.NewFromFloat(1000.00),
 			1609545600: decimal.NewFromFloat(1100.00),
 		}, nil
+	case "delisted-coin":
+		return nil, fmt.Errorf("%w for %s", currency.ErrRatesNotFound, coinID)
 	default:
 		return nil, fmt.Errorf("no rates for coin: %s", coinID)
 	}
@@ -32,13 +44,297 @@ func TestGetExchangeRates(t *testing.T) {
 		"ethereum": {From: 1609459200, To: 1609545600},
 	}
 
-	// Call GetExchangeRates with the mock fetch function
-	exchangeRates, err := GetExchangeRates(currencyUsageMap, "usd", mockFetchExchangeRates)
+	// Call GetExchangeRates with the mock provider
+	exchangeRates, _, err := GetExchangeRates(context.Background(), currencyUsageMap, "usd", mockRateProvider{}, PegPolicy{}, false)
 
 	assert.NoError(t, err)
 	assert.NotNil(t, exchangeRates)
-	assert.Equal(t, decimal.NewFromFloat(30000.00), exchangeRates["bitcoin"][1609459200])
-	assert.Equal(t, decimal.NewFromFloat(31000.00), exchangeRates["bitcoin"][1609545600])
-	assert.Equal(t, decimal.NewFromFloat(1000.00), exchangeRates["ethereum"][1609459200])
-	assert.Equal(t, decimal.NewFromFloat(1100.00), exchangeRates["ethereum"][1609545600])
+	assertRate(t, exchangeRates["bitcoin"], 1609459200, "30000")
+	assertRate(t, exchangeRates["bitcoin"], 1609545600, "31000")
+	assertRate(t, exchangeRates["ethereum"], 1609459200, "1000")
+	assertRate(t, exchangeRates["ethereum"], 1609545600, "1100")
+	assert.Equal(t, "mock", exchangeRates["bitcoin"].source)
+}
+
+func assertRate(t *testing.T, series RateSeries, timestamp int64, expected string) {
+	t.Helper()
+	rate, exists := series.Rate(timestamp)
+	if assert.True(t, exists, "Expected a rate at %d", timestamp) {
+		assert.Equal(t, expected, rate.String())
+	}
+}
+
+func TestGetExchangeRates_MissingCoin(t *testing.T) {
+	currencyUsageMap := CurrencyUsageMap{
+		"bitcoin":       {From: 1609459200, To: 1609545600},
+		"delisted-coin": {From: 1609459200, To: 1609545600},
+	}
+
+	exchangeRates, _, err := GetExchangeRates(context.Background(), currencyUsageMap, "usd", mockRateProvider{}, PegPolicy{}, false)
+	assert.NoError(t, err, "Expected a coin without rates to be left unpriced instead of failing the run")
+	assert.Contains(t, exchangeRates, "bitcoin")
+	if assert.Contains(t, exchangeRates, "delisted-coin", "Expected a coin without rates to keep an empty series") {
+		assert.Zero(t, exchangeRates["delisted-coin"].Len())
+	}
+
+	currencyUsageMap["broken-coin"] = CurrencyUsage{From: 1609459200, To: 1609545600}
+	_, _, err = GetExchangeRates(context.Background(), currencyUsageMap, "usd", mockRateProvider{}, PegPolicy{}, false)
+	assert.EqualError(t, err, "failed to get exchange rates for broken-coin: no rates for coin: broken-coin")
+}
+
+func TestGetExchangeRates_ReportsEveryFailure(t *testing.T) {
+	currencyUsageMap := CurrencyUsageMap{
+		"bitcoin":       {From: 1609459200, To: 1609545600},
+		"broken-coin":   {From: 1609459200, To: 1609545600},
+		"another-coin":  {From: 1609459200, To: 1609545600},
+		"delisted-coin": {From: 1609459200, To: 1609545600},
+	}
+
+	_, _, err := GetExchangeRates(context.Background(), currencyUsageMap, "usd", mockRateProvider{}, PegPolicy{}, false)
+	assert.EqualError(t, err, "failed to get exchange rates for another-coin: no rates for coin: another-coin\n"+
+		"failed to get exchange rates for broken-coin: no rates for coin: broken-coin")
+}
+
+// Serves the mock rates with a volume of 5 each
+type mockVolumeProvider struct {
+	mockRateProvider
+}
+
+func (p mockVolumeProvider) FetchRatesWithVolumes(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, map[int64]decimal.Decimal, error) {
+	rates, err := p.FetchRates(ctx, coinID, targetCurrency, from, to)
+	volumes := make(map[int64]decimal.Decimal, len(rates))
+	for timestamp := range rates {
+		volumes[timestamp] = decimal.NewFromInt(5)
+	}
+	return rates, volumes, err
+}
+
+func TestGetExchangeRates_Volumes(t *testing.T) {
+	currencyUsageMap := CurrencyUsageMap{"bitcoin": {From: 1609459200, To: 1609545600}}
+
+	exchangeRates, _, err := GetExchangeRates(context.Background(), currencyUsageMap, "usd", mockVolumeProvider{}, PegPolicy{}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, []decimal.Decimal{decimal.NewFromInt(5), decimal.NewFromInt(5)}, exchangeRates["bitcoin"].volumes)
+
+	exchangeRates, _, err = GetExchangeRates(context.Background(), currencyUsageMap, "usd", mockVolumeProvider{}, PegPolicy{}, false)
+	assert.NoError(t, err)
+	assert.Nil(t, exchangeRates["bitcoin"].volumes, "Expected no volumes unless asked for")
+
+	exchangeRates, _, err = GetExchangeRates(context.Background(), currencyUsageMap, "usd", mockRateProvider{}, PegPolicy{}, true)
+	assert.NoError(t, err)
+	assert.Nil(t, exchangeRates["bitcoin"].volumes, "Expected no volumes from a provider without them")
+}
+
+func TestFetchedExchangeRates_SnapshotSeries(t *testing.T) {
+	day := time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC).Unix()
+	fetchRanges := CurrencyUsageMap{
+		"bitcoin":  {From: day, To: day + 3*secondsPerDay},
+		"usd-coin": {From: day, To: day + 3*secondsPerDay},
+	}
+	exchangeRates := FetchedExchangeRates{
+		// Rates at both ends of the range only, like the peg provider returns them
+		"bitcoin": NewRateSeriesWithVolumes(
+			map[int64]decimal.Decimal{day: decimal.NewFromInt(1), day + 3*secondsPerDay: decimal.NewFromInt(1)},
+			map[int64]decimal.Decimal{day: decimal.NewFromInt(7)},
+		),
+		"usd-coin": newPeggedRateSeries(decimal.NewFromInt(1), day, day+3*secondsPerDay),
+	}
+
+	series := exchangeRates.SnapshotSeries("USD", fetchRanges)
+	if assert.Len(t, series, 1, "Expected pegged series to be left out") {
+		assert.Equal(t, "bitcoin", series[0].CoinID)
+		assert.Equal(t, "usd", series[0].Currency)
+		assert.Equal(t, []currency.TimeRange{{From: day, To: day + 3*secondsPerDay}}, series[0].Coverage, "Expected the whole fetched range to be covered")
+		assert.Len(t, series[0].Rates, 2)
+		assert.Equal(t, "7", series[0].Volumes[day].String())
+		assert.True(t, series[0].Volumes[day+3*secondsPerDay].IsZero())
+	}
+}
+
+// An offline rerun from the snapshot of an online run prices the events the same way
+func TestSnapshotSeries_OfflineRoundTrip(t *testing.T) {
+	fetchRanges := CurrencyUsageMap{
+		"bitcoin":       {From: 1609459200, To: 1609545600},
+		"delisted-coin": {From: 1609459200, To: 1609545600},
+	}
+	events := []Event{
+		NewEvent(time.Unix(1609459200, 0), "bitcoin", "BUY_ITEMS", "BTC", 1, decimal.Zero, decimal.NewFromInt(1)),
+		NewEvent(time.Unix(1609459200, 0), "delisted-coin", "BUY_ITEMS", "DEL", 1, decimal.Zero, decimal.NewFromInt(1)),
+	}
+	matching := RateMatching{Strategy: RateStrategyNearest}
+
+	online, _, err := GetExchangeRates(context.Background(), fetchRanges, "usd", mockRateProvider{}, PegPolicy{}, false)
+	assert.NoError(t, err)
+	onlineReport := UpdateExchangeRates(events, online, matching)
+
+	snapshot := currency.NewRateSnapshot(online.SnapshotSeries("usd", fetchRanges), time.Now())
+	offline, _, err := GetExchangeRates(context.Background(), fetchRanges, "usd", currency.NewSnapshotProvider(snapshot), PegPolicy{}, false)
+	assert.NoError(t, err, "Expected the coin without rates not to be missing from the snapshot")
+	offlineReport := UpdateExchangeRates(events, offline, matching)
+
+	assert.Equal(t, onlineReport, offlineReport)
+	assert.Equal(t, map[string]map[string]int{"delisted-coin": {UnpricedNoRates: 1}}, offlineReport.Unpriced)
+	assertRate(t, offline["bitcoin"], 1609459200, "30000")
+}
+
+func TestParseTargetCurrencies(t *testing.T) {
+	currencies, err := ParseTargetCurrencies("usd, eur,,USD,pln", "gbp")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"usd", "eur", "pln"}, currencies)
+
+	currencies, err = ParseTargetCurrencies("", "usd")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"usd"}, currencies, "Expected the default currency when no list is set")
+
+	_, err = ParseTargetCurrencies(" , ", "")
+	assert.EqualError(t, err, "no target currency configured")
+}
+
+func TestUpdateExchangeRates_PerCurrency(t *testing.T) {
+	day := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	events := []Event{
+		NewEvent(day, "bitcoin", "BUY_ITEMS", "BTC", 1, decimal.Zero, decimal.NewFromInt(2)),
+	}
+	rates := map[string]FetchedExchangeRates{
+		"usd": {"bitcoin": NewRateSeries(map[int64]decimal.Decimal{day.Unix(): decimal.NewFromInt(30000)})},
+		"eur": {"bitcoin": NewRateSeries(map[int64]decimal.Decimal{day.Unix(): decimal.NewFromInt(25000)})},
+	}
+
+	var aggregated []AggregatePerProject
+	for _, targetCurrency := range []string{"usd", "eur"} {
+		UpdateExchangeRates(events, rates[targetCurrency], RateMatching{Strategy: RateStrategyNearest})
+		aggregated = append(aggregated, AggregateEvents(events, targetCurrency)...)
+	}
+
+	assert.Equal(t, []string{"2021-01-01|1|1|60000|usd", "2021-01-01|1|1|50000|eur"}, aggregateStrings(aggregated))
+}
+
+func TestRateSeries(t *testing.T) {
+	series := NewRateSeries(map[int64]decimal.Decimal{
+		300: decimal.NewFromInt(3),
+		100: decimal.NewFromInt(1),
+		200: decimal.NewFromInt(2),
+	})
+
+	assert.Equal(t, 3, series.Len())
+	for i, expected := range []int64{100, 200, 300} {
+		timestamp, rate := series.At(i)
+		assert.Equal(t, expected, timestamp, "Expected the series to be sorted by timestamp")
+		assert.Equal(t, expected/100, rate.IntPart())
+	}
+
+	_, exists := series.Rate(150)
+	assert.False(t, exists)
+
+	prevTs, _, hasPrev, nextTs, _, hasNext := series.surrounding(150)
+	assert.True(t, hasPrev && hasNext)
+	assert.Equal(t, int64(100), prevTs)
+	assert.Equal(t, int64(200), nextTs)
+
+	_, _, hasPrev, nextTs, _, _ = series.surrounding(50)
+	assert.False(t, hasPrev)
+	assert.Equal(t, int64(100), nextTs)
+
+	prevTs, _, _, _, _, hasNext = series.surrounding(400)
+	assert.False(t, hasNext)
+	assert.Equal(t, int64(300), prevTs)
+}
+
+const (
+	benchmarkEvents = 1_000_000
+	benchmarkCoins  = 10
+	benchmarkDays   = 90
+)
+
+// Builds hourly rates for benchmarkDays and a million events spread randomly over them
+func syntheticPricingInput() (map[string]map[int64]decimal.Decimal, []Event) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	random := rand.New(rand.NewSource(1))
+
+	rates := make(map[string]map[int64]decimal.Decimal, benchmarkCoins)
+	coinIDs := make([]string, benchmarkCoins)
+	for c := range coinIDs {
+		coinIDs[c] = fmt.Sprintf("coin-%d", c)
+		rates[coinIDs[c]] = make(map[int64]decimal.Decimal, benchmarkDays*24)
+		for hour := 0; hour < benchmarkDays*24; hour++ {
+			rates[coinIDs[c]][start.Add(time.Duration(hour)*time.Hour).Unix()] = decimal.NewFromInt(int64(1000 + hour))
+		}
+	}
+
+	events := make([]Event, benchmarkEvents)
+	for i := range events {
+		ts := start.Add(time.Duration(random.Int63n(int64(benchmarkDays * 24 * time.Hour))))
+		events[i] = NewEvent(ts, coinIDs[random.Intn(benchmarkCoins)], "BUY_ITEMS", "COIN", 1, decimal.Zero, decimal.NewFromInt(1))
+	}
+	return rates, events
+}
+
+// The previous lookup, a scan over every rate of the coin
+func linearScanClosestRate(rates map[int64]decimal.Decimal, tsUnix int64) decimal.Decimal {
+	var closest decimal.Decimal
+	minDiff := int64(-1)
+	for timestamp, rate := range rates {
+		diff := timestamp - tsUnix
+		if diff < 0 {
+			diff = -diff
+		}
+		if minDiff < 0 || diff < minDiff {
+			minDiff, closest = diff, rate
+		}
+	}
+	return closest
+}
+
+func BenchmarkRateLookup_LinearScan(b *testing.B) {
+	rates, events := syntheticPricingInput()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		event := events[i%len(events)]
+		linearScanClosestRate(rates[event.CoinID], event.TsUnix)
+	}
+}
+
+func BenchmarkRateLookup_BinarySearch(b *testing.B) {
+	rates, events := syntheticPricingInput()
+	exchangeRates := make(FetchedExchangeRates, len(rates))
+	for coinID, coinRates := range rates {
+		exchangeRates[coinID] = NewRateSeries(coinRates)
+	}
+	matching := RateMatching{Strategy: RateStrategyNearest}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		event := events[i%len(events)]
+		matching.match(exchangeRates[event.CoinID], event.TsUnix)
+	}
+}
+
+// Prices the whole synthetic input, the linear scan takes minutes at this size
+func BenchmarkUpdateExchangeRates_MillionEvents(b *testing.B) {
+	rates, events := syntheticPricingInput()
+	exchangeRates := make(FetchedExchangeRates, len(rates))
+	for coinID, coinRates := range rates {
+		exchangeRates[coinID] = NewRateSeries(coinRates)
+	}
+	matching := RateMatching{Strategy: RateStrategyNearest}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		UpdateExchangeRates(events, exchangeRates, matching)
+	}
+}
+
+func TestFetchedExchangeRates_Records(t *testing.T) {
+	bitcoin := NewRateSeries(map[int64]decimal.Decimal{200: decimal.NewFromInt(2), 100: decimal.NewFromInt(1)})
+	bitcoin.source = "coingecko"
+	exchangeRates := FetchedExchangeRates{
+		"usd-coin": newPeggedRateSeries(decimal.NewFromInt(1), 100, 100),
+		"bitcoin":  bitcoin,
+	}
+
+	records := exchangeRates.Records("usd")
+
+	assert.Equal(t, []ExchangeRate{
+		{CoinID: "bitcoin", Currency: "usd", Timestamp: 100, Rate: decimal.NewFromInt(1), Source: "coingecko"},
+		{CoinID: "bitcoin", Currency: "usd", Timestamp: 200, Rate: decimal.NewFromInt(2), Source: "coingecko"},
+		{CoinID: "usd-coin", Currency: "usd", Timestamp: 100, Rate: decimal.NewFromInt(1), Source: "peg"},
+	}, records)
 }
@@ -0,0 +1,139 @@
+package etl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+)
+
+// Stages at which a row can be rejected
+const (
+	StageRead        = "read"
+	StageParse       = "parse"
+	StageCoinMapping = "coin_mapping"
+)
+
+// Reasons for rejecting a row
+const (
+	ReasonInvalidRow           = "invalid_row"
+	ReasonShortRow             = "short_row"
+	ReasonInvalidTimestamp     = "invalid_timestamp"
+	ReasonInvalidProjectID     = "invalid_project_id"
+	ReasonInvalidProps         = "invalid_props"
+	ReasonInvalidNums          = "invalid_nums"
+	ReasonInvalidCurrencyValue = "invalid_currency_value"
+	ReasonUnknownDecimals      = "unknown_decimals"
+	ReasonUnmappedCurrency     = "unmapped_currency"
+	ReasonAmbiguousCurrency    = "ambiguous_currency"
+)
+
+// Describes why and where a row was rejected
+type RowError struct {
+	Stage  string
+	Reason string
+	Err    error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("%s/%s: %v", e.Stage, e.Reason, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+func newRowError(stage, reason string, err error) *RowError {
+	return &RowError{Stage: stage, Reason: reason, Err: err}
+}
+
+// A rejected row as written to the dead-letter output
+type DeadLetter struct {
+	Object string   `json:"object"`
+	Stage  string   `json:"stage"`
+	Reason string   `json:"reason"`
+	Error  string   `json:"error"`
+	Row    []string `json:"row"`
+}
+
+// Collects rejected rows from all workers, writes them as NDJSON to the
+// dead-letter output (if any) and counts them per stage and reason.
+type DeadLetters struct {
+	mu      sync.Mutex
+	writer  io.WriteCloser
+	encoder *json.Encoder
+	counts  map[string]int
+}
+
+// Create a dead-letter collector, writer may be nil to only count rejected rows
+func NewDeadLetters(writer io.WriteCloser) *DeadLetters {
+	deadLetters := &DeadLetters{writer: writer, counts: make(map[string]int)}
+	if writer != nil {
+		deadLetters.encoder = json.NewEncoder(writer)
+	}
+	return deadLetters
+}
+
+// Record a rejected row. Errors that are not a RowError are counted as invalid rows.
+func (d *DeadLetters) Reject(object string, values []string, err error) {
+	var rowErr *RowError
+	if !errors.As(err, &rowErr) {
+		rowErr = newRowError(StageRead, ReasonInvalidRow, err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.counts[rowErr.Stage+"/"+rowErr.Reason]++
+	if d.encoder == nil {
+		return
+	}
+
+	letter := DeadLetter{Object: object, Stage: rowErr.Stage, Reason: rowErr.Reason, Error: rowErr.Err.Error(), Row: values}
+	if err := d.encoder.Encode(letter); err != nil {
+		log.Printf("failed to write dead letter: %v", err)
+	}
+}
+
+// Number of rejected rows keyed by "stage/reason"
+func (d *DeadLetters) Counts() map[string]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	counts := make(map[string]int, len(d.counts))
+	for key, count := range d.counts {
+		counts[key] = count
+	}
+	return counts
+}
+
+func (d *DeadLetters) LogSummary() {
+	counts := d.Counts()
+	if len(counts) == 0 {
+		log.Println("No rows rejected")
+		return
+	}
+
+	keys := make([]string, 0, len(counts))
+	total := 0
+	for key, count := range counts {
+		keys = append(keys, key)
+		total += count
+	}
+	sort.Strings(keys)
+
+	log.Printf("Rejected %d rows:", total)
+	for _, key := range keys {
+		log.Printf("  %s: %d", key, counts[key])
+	}
+}
+
+func (d *DeadLetters) Close() error {
+	if d.writer == nil {
+		return nil
+	}
+	return d.writer.Close()
+}
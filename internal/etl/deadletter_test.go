@@ -0,0 +1,52 @@
+package etl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestDeadLetters(t *testing.T) {
+	var buf bytes.Buffer
+	deadLetters := NewDeadLetters(nopWriteCloser{&buf})
+
+	deadLetters.Reject("a.csv", []string{"x", "y"}, newRowError(StageParse, ReasonInvalidTimestamp, errors.New("bad ts")))
+	deadLetters.Reject("a.csv", []string{"z"}, newRowError(StageParse, ReasonInvalidTimestamp, errors.New("bad ts")))
+	deadLetters.Reject("b.csv", nil, errors.New("record on line 3: wrong number of fields"))
+	assert.NoError(t, deadLetters.Close())
+
+	assert.Equal(t, map[string]int{"parse/invalid_timestamp": 2, "read/invalid_row": 1}, deadLetters.Counts())
+
+	var letters []DeadLetter
+	scanner := bufio.NewScanner(&buf)
+	for scanner.Scan() {
+		var letter DeadLetter
+		assert.NoError(t, json.Unmarshal(scanner.Bytes(), &letter))
+		letters = append(letters, letter)
+	}
+	assert.Equal(t, []DeadLetter{
+		{Object: "a.csv", Stage: StageParse, Reason: ReasonInvalidTimestamp, Error: "bad ts", Row: []string{"x", "y"}},
+		{Object: "a.csv", Stage: StageParse, Reason: ReasonInvalidTimestamp, Error: "bad ts", Row: []string{"z"}},
+		{Object: "b.csv", Stage: StageRead, Reason: ReasonInvalidRow, Error: "record on line 3: wrong number of fields"},
+	}, letters)
+}
+
+func TestDeadLetters_CountOnly(t *testing.T) {
+	deadLetters := NewDeadLetters(nil)
+
+	deadLetters.Reject("a.csv", []string{"x"}, newRowError(StageCoinMapping, ReasonUnmappedCurrency, errors.New("no coin")))
+
+	assert.Equal(t, map[string]int{"coin_mapping/unmapped_currency": 1}, deadLetters.Counts())
+	assert.NoError(t, deadLetters.Close())
+}
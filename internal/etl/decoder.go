@@ -0,0 +1,96 @@
+package etl
+
+import (
+	"bdaggregator/internal/storage/compression"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Supported input formats
+const (
+	FormatCSV     = "csv"
+	FormatNDJSON  = "ndjson"
+	FormatParquet = "parquet"
+)
+
+var formatExtensions = map[string]string{
+	".csv":     FormatCSV,
+	".ndjson":  FormatNDJSON,
+	".jsonl":   FormatNDJSON,
+	".json":    FormatNDJSON,
+	".parquet": FormatParquet,
+	".pq":      FormatParquet,
+}
+
+// Reads the rows of a single input object. Like csv.Reader, the first row
+// returned is the header and io.EOF marks the end of the object. A DecodeError
+// rejects a single row and reading continues with the next one, any other
+// error (e.g. a failed or truncated download) ends the object.
+type RowReader interface {
+	Read() ([]string, error)
+}
+
+// DecodeError is a row that could not be decoded, e.g. invalid JSON or a wrong number of CSV fields
+type DecodeError struct {
+	Err error
+}
+
+func (e *DecodeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Reports CSV syntax errors as DecodeErrors, csv.Reader returns read errors unwrapped
+type csvRowReader struct {
+	reader *csv.Reader
+}
+
+func (r csvRowReader) Read() ([]string, error) {
+	row, err := r.reader.Read()
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return row, &DecodeError{Err: err}
+	}
+	return row, err
+}
+
+// Select the input format of an object. A configured format applies to every
+// object, otherwise it is taken from the extension (ignoring compression
+// extensions such as .gz) and defaults to CSV.
+func DetectFormat(configuredFormat, objectName string) (string, error) {
+	if configuredFormat != "" {
+		format := strings.ToLower(configuredFormat)
+		switch format {
+		case FormatCSV, FormatNDJSON, FormatParquet:
+			return format, nil
+		default:
+			return "", fmt.Errorf("unsupported input format: %s", configuredFormat)
+		}
+	}
+
+	if format, exists := formatExtensions[strings.ToLower(path.Ext(compression.TrimExt(objectName)))]; exists {
+		return format, nil
+	}
+	return FormatCSV, nil
+}
+
+// Create a row reader decoding the given format
+func NewRowReader(format string, reader io.Reader) (RowReader, error) {
+	switch format {
+	case FormatCSV:
+		return csvRowReader{reader: csv.NewReader(reader)}, nil
+	case FormatNDJSON:
+		return NewNDJSONReader(reader), nil
+	case FormatParquet:
+		return NewParquetReader(reader)
+	default:
+		return nil, fmt.Errorf("unsupported input format: %s", format)
+	}
+}
@@ -0,0 +1,71 @@
+package etl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Longest accepted line, props of a single event are well below it. A longer
+// line fails the whole object with bufio.ErrTooLong.
+const maxNDJSONLineSize = 1024 * 1024
+
+// Decodes newline-delimited JSON into rows of the required columns. Nested
+// objects (e.g. props and nums) may be JSON objects or JSON-encoded strings.
+type NDJSONReader struct {
+	scanner    *bufio.Scanner
+	headerSent bool
+	line       int
+}
+
+func NewNDJSONReader(reader io.Reader) *NDJSONReader {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLineSize)
+	return &NDJSONReader{scanner: scanner}
+}
+
+func (r *NDJSONReader) Read() ([]string, error) {
+	if !r.headerSent {
+		r.headerSent = true
+		return RequiredColumns, nil
+	}
+
+	for r.scanner.Scan() {
+		r.line++
+		line := bytes.TrimSpace(r.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return nil, &DecodeError{Err: fmt.Errorf("line %d: %w", r.line, err)}
+		}
+
+		row := make([]string, len(RequiredColumns))
+		for i, name := range RequiredColumns {
+			row[i] = jsonFieldToString(fields[name])
+		}
+		return row, nil
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+// Strings are unquoted, any other JSON value is kept as its raw text
+func jsonFieldToString(raw json.RawMessage) string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return ""
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return value
+	}
+	return string(raw)
+}
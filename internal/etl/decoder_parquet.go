@@ -0,0 +1,101 @@
+package etl
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet/file"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+)
+
+// Rows decoded from a single record batch
+const parquetBatchSize = 1024
+
+// Decodes a Parquet object into rows named by the top level columns of its
+// schema. Parquet needs random access, so the object is read into memory.
+type ParquetReader struct {
+	records    pqarrow.RecordReader
+	header     []string
+	record     arrow.Record
+	rowIndex   int
+	headerSent bool
+	done       bool
+}
+
+func NewParquetReader(reader io.Reader) (*ParquetReader, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	parquetFile, err := file.NewParquetReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	fileReader, err := pqarrow.NewFileReader(parquetFile, pqarrow.ArrowReadProperties{BatchSize: parquetBatchSize}, memory.DefaultAllocator)
+	if err != nil {
+		return nil, err
+	}
+	records, err := fileReader.GetRecordReader(context.Background(), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var header []string
+	for _, field := range records.Schema().Fields() {
+		header = append(header, field.Name)
+	}
+
+	return &ParquetReader{records: records, header: header}, nil
+}
+
+func (r *ParquetReader) Read() ([]string, error) {
+	if !r.headerSent {
+		r.headerSent = true
+		return r.header, nil
+	}
+
+	if r.done {
+		return nil, io.EOF
+	}
+
+	for r.record == nil || r.rowIndex >= int(r.record.NumRows()) {
+		if !r.records.Next() {
+			// The reader is released once, later calls only return io.EOF
+			err := r.records.Err()
+			r.records.Release()
+			r.done = true
+			if err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		r.record = r.records.Record()
+		r.rowIndex = 0
+	}
+
+	row := make([]string, r.record.NumCols())
+	for i, column := range r.record.Columns() {
+		row[i] = parquetValueToString(column, r.rowIndex)
+	}
+	r.rowIndex++
+
+	return row, nil
+}
+
+// Timestamps use the same layout as the CSV export, nested values become JSON
+func parquetValueToString(column arrow.Array, i int) string {
+	if column.IsNull(i) {
+		return ""
+	}
+
+	if timestamps, ok := column.(*array.Timestamp); ok {
+		unit := timestamps.DataType().(*arrow.TimestampType).Unit
+		return timestamps.Value(i).ToTime(unit).UTC().Format("2006-01-02 15:04:05.000")
+	}
+	return column.ValueStr(i)
+}
@@ -0,0 +1,120 @@
+package etl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v15/arrow"
+	"github.com/apache/arrow/go/v15/arrow/array"
+	"github.com/apache/arrow/go/v15/arrow/memory"
+	"github.com/apache/arrow/go/v15/parquet/pqarrow"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectFormat(t *testing.T) {
+	tests := []struct {
+		configuredFormat string
+		objectName       string
+		expected         string
+	}{
+		{"", "exports/2024-04-15.csv", FormatCSV},
+		{"", "exports/2024-04-15.csv.gz", FormatCSV},
+		{"", "exports/2024-04-15.ndjson.zst", FormatNDJSON},
+		{"", "exports/2024-04-15.jsonl", FormatNDJSON},
+		{"", "exports/2024-04-15.parquet", FormatParquet},
+		{"", "exports/2024-04-15", FormatCSV},
+		{"NDJSON", "exports/2024-04-15.csv", FormatNDJSON},
+	}
+
+	for _, tt := range tests {
+		format, err := DetectFormat(tt.configuredFormat, tt.objectName)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, format, "DetectFormat(%q, %q)", tt.configuredFormat, tt.objectName)
+	}
+
+	_, err := DetectFormat("xml", "exports/2024-04-15.xml")
+	assert.Error(t, err, "Expected an error for an unsupported configured format")
+}
+
+func TestNDJSONReader(t *testing.T) {
+	data := `{"ts":"2024-04-15 02:15:07.167","event":"BUY_ITEMS","project_id":4974,"props":{"currencySymbol":"SFL","chainId":"137"},"nums":{"currencyValueDecimal":0.61}}
+
+{"ts":"2024-04-15 02:26:37.134","event":"BUY_ITEMS","project_id":"1609","props":"{\"currencySymbol\":\"USDC\"}","nums":"{\"currencyValueDecimal\":\"2.36\"}"}
+not json
+{"ts":"2024-04-15 03:00:00.000","event":"BUY_ITEMS","project_id":1}
+`
+	reader := NewNDJSONReader(strings.NewReader(data))
+
+	header, err := reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, RequiredColumns, header, "Expected the required columns as header")
+
+	row, err := reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2024-04-15 02:15:07.167", "BUY_ITEMS", "4974", `{"currencySymbol":"SFL","chainId":"137"}`, `{"currencyValueDecimal":0.61}`}, row)
+
+	row, err = reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2024-04-15 02:26:37.134", "BUY_ITEMS", "1609", `{"currencySymbol":"USDC"}`, `{"currencyValueDecimal":"2.36"}`}, row)
+
+	_, err = reader.Read()
+	var decodeErr *DecodeError
+	assert.True(t, errors.As(err, &decodeErr), "Expected a DecodeError for an invalid line")
+	assert.Contains(t, err.Error(), "line 4")
+
+	row, err = reader.Read()
+	assert.NoError(t, err, "Expected reading to continue after an invalid line")
+	assert.Equal(t, []string{"2024-04-15 03:00:00.000", "BUY_ITEMS", "1", "", ""}, row, "Expected missing fields to be empty")
+
+	_, err = reader.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestParquetReader(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "ts", Type: &arrow.TimestampType{Unit: arrow.Millisecond}},
+		{Name: "event", Type: arrow.BinaryTypes.String},
+		{Name: "project_id", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "props", Type: arrow.BinaryTypes.String},
+		{Name: "nums", Type: arrow.BinaryTypes.String, Nullable: true},
+	}, nil)
+
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+
+	ts := time.Date(2024, 4, 15, 2, 15, 7, 167000000, time.UTC)
+	builder.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(ts.UnixMilli()))
+	builder.Field(1).(*array.StringBuilder).Append("BUY_ITEMS")
+	builder.Field(2).(*array.Int64Builder).Append(4974)
+	builder.Field(3).(*array.StringBuilder).Append(`{"currencySymbol":"SFL"}`)
+	builder.Field(4).(*array.StringBuilder).AppendNull()
+
+	record := builder.NewRecord()
+	defer record.Release()
+	table := array.NewTableFromRecords(schema, []arrow.Record{record})
+	defer table.Release()
+
+	var buf bytes.Buffer
+	err := pqarrow.WriteTable(table, &buf, 1024, nil, pqarrow.DefaultWriterProps())
+	assert.NoError(t, err, "Expected no error writing Parquet data")
+
+	reader, err := NewParquetReader(&buf)
+	assert.NoError(t, err, "Expected no error opening Parquet data")
+
+	header, err := reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ts", "event", "project_id", "props", "nums"}, header)
+
+	row, err := reader.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2024-04-15 02:15:07.167", "BUY_ITEMS", "4974", `{"currencySymbol":"SFL"}`, ""}, row)
+
+	_, err = reader.Read()
+	assert.Equal(t, io.EOF, err)
+	_, err = reader.Read()
+	assert.Equal(t, io.EOF, err, "Expected reads after the end to keep returning io.EOF")
+}
@@ -2,15 +2,24 @@ package etl
 
 import (
 	"bdaggregator/internal/currency"
+	"fmt"
 	"io"
 	"log"
 	"sync"
 )
 
-// Process the CSV and extracts events and currency usage information
-func ExtractEvents(reader io.Reader, coins []currency.Coin) ([]Event, CurrencyUsageMap) {
-	rowChan := make(chan []string, 100)
+// Opens a named object for reading, satisfied by storage.Storage
+type ObjectSource interface {
+	Open(name string) (io.ReadCloser, error)
+}
+
+// Process the objects as one stream and extracts events and currency usage information.
+// The input format is detected per object unless inputFormat is set, rejected
+// rows are handed to deadLetters.
+func ExtractEvents(source ObjectSource, objectNames []string, inputFormat string, coinIndex currency.CoinResolver, decimalsRegistry *currency.DecimalsRegistry, deadLetters *DeadLetters) ([]Event, CurrencyUsageMap, error) {
+	rowChan := make(chan Row, 100)
 	eventChan := make(chan Event, 100)
+	errChan := make(chan error, 1)
 
 	var events []Event
 	currencyUsageMap := make(CurrencyUsageMap)
@@ -18,39 +27,84 @@ func ExtractEvents(reader io.Reader, coins []currency.Coin) ([]Event, CurrencyUs
 
 	var wg sync.WaitGroup
 
-	// Read CSV rows and send them to rowChan
-	go ReadCSVRows(reader, rowChan)
+	// Read rows of every object and send them to rowChan
+	go func() {
+		errChan <- ReadObjects(source, objectNames, inputFormat, rowChan, deadLetters)
+	}()
 
 	// Start workers to process rows, build CurrencyUsageMap, and send events
 	numWorkers := 4
-	startWorkers(rowChan, eventChan, &wg, numWorkers, currencyUsageMap, &mu, coins)
+	startWorkers(rowChan, eventChan, &wg, numWorkers, currencyUsageMap, &mu, coinIndex, decimalsRegistry, deadLetters)
 
 	events = CollectEvents(eventChan)
+	if err := <-errChan; err != nil {
+		return nil, nil, err
+	}
 	SortEventsByTimestamp(events)
 
 	// Print currency usage for debugging purposes
 	PrintCurrencyUsage(currencyUsageMap)
 
-	return events, currencyUsageMap
+	return events, currencyUsageMap, nil
+}
+
+// Read the objects one after another into a single row stream, closing it when done
+func ReadObjects(source ObjectSource, objectNames []string, inputFormat string, rowChan chan<- Row, deadLetters *DeadLetters) error {
+	defer close(rowChan)
+
+	for i, name := range objectNames {
+		format, err := DetectFormat(inputFormat, name)
+		if err != nil {
+			return err
+		}
+		log.Printf("Reading object %d/%d: %s (%s)", i+1, len(objectNames), name, format)
+
+		rowCount, err := readObject(source, name, format, rowChan, deadLetters)
+		if err != nil {
+			return fmt.Errorf("failed to read object %s: %w", name, err)
+		}
+
+		log.Printf("Finished object %d/%d: %s (%d rows)", i+1, len(objectNames), name, rowCount)
+	}
+
+	return nil
+}
+
+func readObject(source ObjectSource, name, format string, rowChan chan<- Row, deadLetters *DeadLetters) (int, error) {
+	reader, err := source.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer reader.Close()
+
+	rowReader, err := NewRowReader(format, reader)
+	if err != nil {
+		return 0, err
+	}
+
+	return ReadRows(rowReader, name, rowChan, deadLetters)
 }
 
 func startWorkers(
-	rowChan <-chan []string,
+	rowChan <-chan Row,
 	eventChan chan<- Event,
 	wg *sync.WaitGroup,
 	numWorkers int,
 	currencyUsageMap CurrencyUsageMap,
 	mu *sync.Mutex,
-	coins []currency.Coin,
+	coinIndex currency.CoinResolver,
+	decimalsRegistry *currency.DecimalsRegistry,
+	deadLetters *DeadLetters,
 ) {
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
 			for row := range rowChan {
-				event, err := ParseRowToEvent(row, currencyUsageMap, mu, coins)
+				event, err := ParseRowToEvent(row, currencyUsageMap, mu, coinIndex, decimalsRegistry)
 				if err != nil {
 					log.Printf("Worker %d: failed to parse row: %v", workerID, err)
+					deadLetters.Reject(row.Object, row.Values, err)
 					continue
 				}
 				eventChan <- event
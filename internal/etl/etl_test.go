@@ -4,8 +4,10 @@ import (
 	"bdaggregator/internal/currency"
 	"bytes"
 	"encoding/csv"
+	"fmt"
 	"io"
 	"log"
+	"strings"
 	"sync"
 	"testing"
 
@@ -13,6 +15,19 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+const csvHeader = `"app","ts","event","project_id","source","ident","user_id","session_id","country","device_type","device_os","device_os_ver","device_browser","device_browser_ver","props","nums"`
+
+// In-memory object source for ExtractEvents tests
+type mockSource map[string]string
+
+func (m mockSource) Open(name string) (io.ReadCloser, error) {
+	data, ok := m[name]
+	if !ok {
+		return nil, fmt.Errorf("object %s not found", name)
+	}
+	return io.NopCloser(strings.NewReader(data)), nil
+}
+
 func TestExtractEvents(t *testing.T) {
 	// Sample CSV data
 	csvData := `"app","ts","event","project_id","source","ident","user_id","session_id","country","device_type","device_os","device_os_ver","device_browser","device_browser_ver","props","nums"
@@ -31,15 +46,19 @@ func TestExtractEvents(t *testing.T) {
 	// Initialize CSV reader
 	reader := csv.NewReader(bytes.NewReader([]byte(csvData)))
 	// Read header
-	_, err := reader.Read()
+	header, err := reader.Read()
 	assert.NoError(t, err, "Expected no error reading CSV header")
+	columns, err := NewColumnMap(header)
+	assert.NoError(t, err, "Expected no error building column map")
 
 	// Prepare channels and map for testing
-	rowChan := make(chan []string, 100)
+	rowChan := make(chan Row, 100)
 	eventChan := make(chan Event, 100)
 	currencyUsageMap := make(CurrencyUsageMap)
 	var mu sync.Mutex
 
+	coinIndex := currency.NewCoinIndex(mockCoins, nil)
+
 	// Launch CSV reading in background
 	go func() {
 		for {
@@ -51,7 +70,7 @@ func TestExtractEvents(t *testing.T) {
 			if err != nil {
 				log.Fatalf("Error reading CSV: %v", err)
 			}
-			rowChan <- row
+			rowChan <- Row{Values: row, Columns: columns}
 		}
 	}()
 
@@ -63,7 +82,7 @@ func TestExtractEvents(t *testing.T) {
 		go func(workerID int) {
 			defer wg.Done()
 			for row := range rowChan {
-				event, err := ParseRowToEvent(row, currencyUsageMap, &mu, mockCoins)
+				event, err := ParseRowToEvent(row, currencyUsageMap, &mu, coinIndex, nil)
 				if err == nil {
 					eventChan <- event
 				} else {
@@ -91,4 +110,138 @@ func TestExtractEvents(t *testing.T) {
 
 	expectedCurrencyValue := decimal.RequireFromString("0.6136203411678249")
 	assert.Equal(t, expectedCurrencyValue, events[0].CurrencyValueDecimal, "Expected parsed CurrencyValueDecimal")
+	assert.Equal(t, "137", events[0].ChainID, "Expected ChainID to be kept from props")
+
+	var txnHashes, rawValues []string
+	for _, event := range events {
+		txnHashes = append(txnHashes, event.TxnHash)
+		rawValues = append(rawValues, event.CurrencyValueRaw)
+	}
+	assert.ElementsMatch(t, []string{
+		"0xd919290e80df271e77d1cbca61f350d2727531e0334266671ec20d626b2104a2",
+		"0x1133d2837267e0de2eddf3655a3df99e055d172cb53c4e8e108e70322438e994",
+	}, txnHashes)
+	assert.ElementsMatch(t, []string{"613620341167824900", "2361412166673735000"}, rawValues)
+}
+
+func TestExtractEvents_MultipleObjects(t *testing.T) {
+	source := mockSource{
+		"exports/2024-04-15.csv": csvHeader + `
+"seq-market","2024-04-15 02:15:07.167","BUY_ITEMS","4974","","1","u1","s1","DE","desktop","linux","x86_64","chrome","122.0.0.0","{""chainId"":""137"",""currencyAddress"":""0xd1f9c58e33933a993a3891f8acfe05a68e1afc05"",""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""1.5""}"
+`,
+		"exports/2024-04-16.csv": csvHeader + `
+"seq-market","2024-04-16 10:00:00.000","BUY_ITEMS","1609","","1","u2","s2","DE","desktop","linux","x86_64","chrome","122.0.0.0","{""chainId"":""137"",""currencyAddress"":""0xd1f9c58e33933a993a3891f8acfe05a68e1afc05"",""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""2.5""}"
+"seq-market","2024-04-14 10:00:00.000","BUY_ITEMS","1609","","1","u2","s2","DE","desktop","linux","x86_64","chrome","122.0.0.0","{""chainId"":""137"",""currencyAddress"":""0xd1f9c58e33933a993a3891f8acfe05a68e1afc05"",""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""3.5""}"
+`,
+	}
+	mockCoins := []currency.Coin{
+		{
+			ID:        "sunflower-land",
+			Symbol:    "SFL",
+			Platforms: map[string]string{"polygon-pos": "0xd1f9c58e33933a993a3891f8acfe05a68e1afc05"},
+		},
+	}
+
+	events, currencyUsageMap, err := ExtractEvents(source, []string{"exports/2024-04-15.csv", "exports/2024-04-16.csv"}, "", currency.NewCoinIndex(mockCoins, nil), nil, NewDeadLetters(nil))
+
+	assert.NoError(t, err, "Expected no error extracting events")
+	assert.Len(t, events, 3, "Expected events from both objects")
+	assert.Equal(t, "2024-04-14", events[0].Ts.Format("2006-01-02"), "Expected events sorted across objects")
+	assert.Equal(t, CurrencyUsage{From: events[0].TsUnix, To: events[2].TsUnix}, currencyUsageMap["sunflower-land"])
+}
+
+func TestExtractEvents_MissingObject(t *testing.T) {
+	source := mockSource{"exports/2024-04-15.csv": csvHeader + "\n"}
+
+	_, _, err := ExtractEvents(source, []string{"exports/2024-04-15.csv", "exports/missing.csv"}, "", currency.NewCoinIndex(nil, nil), nil, NewDeadLetters(nil))
+
+	assert.Error(t, err, "Expected an error for an object that cannot be opened")
+	assert.Contains(t, err.Error(), "exports/missing.csv")
+}
+
+func TestExtractEvents_ReorderedColumns(t *testing.T) {
+	source := mockSource{
+		"reordered.csv": `"nums","props","project_id","event","ts"
+"{""currencyValueDecimal"":""1.5""}","{""chainId"":""137"",""currencyAddress"":""0xd1f9c58e33933a993a3891f8acfe05a68e1afc05"",""currencySymbol"":""SFL""}","4974","BUY_ITEMS","2024-04-15 02:15:07.167"
+`,
+	}
+	mockCoins := []currency.Coin{
+		{
+			ID:        "sunflower-land",
+			Symbol:    "SFL",
+			Platforms: map[string]string{"polygon-pos": "0xd1f9c58e33933a993a3891f8acfe05a68e1afc05"},
+		},
+	}
+
+	events, _, err := ExtractEvents(source, []string{"reordered.csv"}, "", currency.NewCoinIndex(mockCoins, nil), nil, NewDeadLetters(nil))
+
+	assert.NoError(t, err, "Expected no error extracting events")
+	assert.Len(t, events, 1)
+	assert.Equal(t, 4974, events[0].ProjectID)
+	assert.Equal(t, "BUY_ITEMS", events[0].Event)
+	assert.Equal(t, decimal.RequireFromString("1.5"), events[0].CurrencyValueDecimal)
+}
+
+func TestExtractEvents_MissingRequiredColumns(t *testing.T) {
+	source := mockSource{
+		"broken.csv": `"app","ts","event","props"
+"seq-market","2024-04-15 02:15:07.167","BUY_ITEMS","{}"
+`,
+	}
+
+	_, _, err := ExtractEvents(source, []string{"broken.csv"}, "", currency.NewCoinIndex(nil, nil), nil, NewDeadLetters(nil))
+
+	assert.Error(t, err, "Expected extraction to fail fast on a header without required columns")
+	assert.Contains(t, err.Error(), "missing required columns: project_id, nums")
+}
+
+func TestExtractEvents_NDJSON(t *testing.T) {
+	source := mockSource{
+		"exports/2024-04-15.ndjson": `{"ts":"2024-04-15 02:15:07.167","event":"BUY_ITEMS","project_id":4974,"props":{"chainId":"137","currencyAddress":"0xd1f9c58e33933a993a3891f8acfe05a68e1afc05","currencySymbol":"SFL"},"nums":{"currencyValueDecimal":1.5}}
+`,
+	}
+	mockCoins := []currency.Coin{
+		{
+			ID:        "sunflower-land",
+			Symbol:    "SFL",
+			Platforms: map[string]string{"polygon-pos": "0xd1f9c58e33933a993a3891f8acfe05a68e1afc05"},
+		},
+	}
+
+	events, currencyUsageMap, err := ExtractEvents(source, []string{"exports/2024-04-15.ndjson"}, "", currency.NewCoinIndex(mockCoins, nil), nil, NewDeadLetters(nil))
+
+	assert.NoError(t, err, "Expected no error extracting events")
+	assert.Len(t, events, 1)
+	assert.Equal(t, 4974, events[0].ProjectID)
+	assert.Equal(t, decimal.RequireFromString("1.5"), events[0].CurrencyValueDecimal)
+	assert.Contains(t, currencyUsageMap, "sunflower-land")
+}
+
+func TestExtractEvents_DeadLetters(t *testing.T) {
+	source := mockSource{
+		"exports/2024-04-15.csv": csvHeader + `
+"seq-market","2024-04-15 02:15:07.167","BUY_ITEMS","4974","","1","u1","s1","DE","desktop","linux","x86_64","chrome","122.0.0.0","{""chainId"":""137"",""currencyAddress"":""0xd1f9c58e33933a993a3891f8acfe05a68e1afc05"",""currencySymbol"":""SFL""}","{""currencyValueDecimal"":""1.5""}"
+"seq-market","not a timestamp","BUY_ITEMS","4974","","1","u1","s1","DE","desktop","linux","x86_64","chrome","122.0.0.0","{}","{}"
+"seq-market","2024-04-15 03:00:00.000","BUY_ITEMS","4974","","1","u1","s1","DE","desktop","linux","x86_64","chrome","122.0.0.0","{""chainId"":""137"",""currencyAddress"":""0x0"",""currencySymbol"":""XYZ""}","{""currencyValueDecimal"":""1.5""}"
+"seq-market","2024-04-15 04:00:00.000","BUY_ITEMS","4974","","1","u1","s1","DE","desktop","linux","x86_64","chrome","122.0.0.0","{not json}","{}"
+`,
+	}
+	mockCoins := []currency.Coin{
+		{
+			ID:        "sunflower-land",
+			Symbol:    "SFL",
+			Platforms: map[string]string{"polygon-pos": "0xd1f9c58e33933a993a3891f8acfe05a68e1afc05"},
+		},
+	}
+	deadLetters := NewDeadLetters(nil)
+
+	events, _, err := ExtractEvents(source, []string{"exports/2024-04-15.csv"}, "", currency.NewCoinIndex(mockCoins, nil), nil, deadLetters)
+
+	assert.NoError(t, err, "Expected rejected rows not to fail the extraction")
+	assert.Len(t, events, 1)
+	assert.Equal(t, map[string]int{
+		"parse/invalid_timestamp":        1,
+		"parse/invalid_props":            1,
+		"coin_mapping/unmapped_currency": 1,
+	}, deadLetters.Counts())
 }
@@ -2,8 +2,9 @@ package etl
 
 import (
 	"bdaggregator/internal/currency"
-	"encoding/csv"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"sort"
@@ -21,10 +22,16 @@ type Props struct {
 	CurrencyAddress string `json:"currencyAddress"`
 }
 
+// Values may be JSON strings (CSV export) or JSON numbers (NDJSON, Parquet)
 type Nums struct {
-	CurrencyValueDecimal string `json:"currencyValueDecimal"`
+	CurrencyValueDecimal json.Number `json:"currencyValueDecimal"`
+	CurrencyValueRaw     json.Number `json:"currencyValueRaw"`
 }
 
+// Largest relative difference between currencyValueDecimal and the value derived
+// from currencyValueRaw that is still float noise rather than a wrong unit
+var maxCurrencyValueDrift = decimal.New(1, -9)
+
 type Event struct {
 	Ts                   time.Time
 	TsUnix               int64
@@ -32,8 +39,16 @@ type Event struct {
 	ProjectID            int
 	CurrencySymbol       string
 	CoinID               string
+	ChainID              string
+	TxnHash              string
+	CurrencyValueRaw     string // as reported in nums, empty when missing
 	CurrencyExchangeRate decimal.Decimal
 	CurrencyValueDecimal decimal.Decimal
+	Unpriced             bool   // no exchange rate close enough, excluded from volumes
+	UnpricedReason       string // UnpricedNoRates or UnpricedStaleRate
+	RateSource           string // the provider of the exchange rate
+	RateFrom             int64  // timestamps of the rates the exchange rate was taken or
+	RateTo               int64  // interpolated from, zero for a pegged rate
 }
 
 func NewEvent(ts time.Time, coinID, event, currencySymbol string, projectID int, currencyExchangeRate, currencyValueDecimal decimal.Decimal) Event {
@@ -49,67 +64,105 @@ func NewEvent(ts time.Time, coinID, event, currencySymbol string, projectID int,
 	}
 }
 
-func ReadCSVRows(reader io.Reader, rowChan chan<- []string) {
-	defer close(rowChan)
-	csvReader := csv.NewReader(reader)
+// Send the data rows of a single object to rowChan and return how many were read.
+// Columns are looked up by the names in the header, a header without the required
+// columns fails the whole object before any row is sent, rows that cannot be
+// decoded are handed to deadLetters. Any other read error ends the object.
+func ReadRows(rowReader RowReader, object string, rowChan chan<- Row, deadLetters *DeadLetters) (int, error) {
+	rowCount := 0
 
-	// Skip the header row
-	if _, err := csvReader.Read(); err != nil {
-		log.Printf("failed to read or skip header row: %v", err)
-		return
+	header, err := rowReader.Read()
+	if err != nil {
+		return rowCount, fmt.Errorf("failed to read header row: %w", err)
+	}
+	columns, err := NewColumnMap(header)
+	if err != nil {
+		return rowCount, err
 	}
 
 	for {
-		row, err := csvReader.Read()
+		values, err := rowReader.Read()
 		if err == io.EOF {
 			break
 		}
-		if err != nil {
-			log.Printf("failed to read CSV row: %v", err)
+		var decodeErr *DecodeError
+		if errors.As(err, &decodeErr) {
+			log.Printf("failed to read row: %v", err)
+			deadLetters.Reject(object, values, newRowError(StageRead, ReasonInvalidRow, err))
 			continue
 		}
-		rowChan <- row
+		if err != nil {
+			return rowCount, fmt.Errorf("read error after %d rows: %w", rowCount, err)
+		}
+		rowChan <- Row{Object: object, Values: values, Columns: columns}
+		rowCount++
 	}
+
+	return rowCount, nil
 }
 
-func ParseRowToEvent(row []string, currencyUsageMap CurrencyUsageMap, mu *sync.Mutex, coins []currency.Coin) (Event, error) {
+func ParseRowToEvent(row Row, currencyUsageMap CurrencyUsageMap, mu *sync.Mutex, coinIndex currency.CoinResolver, decimalsRegistry *currency.DecimalsRegistry) (Event, error) {
 	// Parse the timestamp
-	ts, err := time.Parse("2006-01-02 15:04:05.000", row[1])
+	tsValue, err := row.Value(ColumnTs)
 	if err != nil {
-		return Event{}, err
+		return Event{}, newRowError(StageParse, ReasonShortRow, err)
+	}
+	ts, err := time.Parse("2006-01-02 15:04:05.000", tsValue)
+	if err != nil {
+		return Event{}, newRowError(StageParse, ReasonInvalidTimestamp, err)
 	}
 
 	// Parse project_id
-	projectID, err := strconv.Atoi(row[3])
+	projectIDValue, err := row.Value(ColumnProjectID)
 	if err != nil {
-		return Event{}, err
+		return Event{}, newRowError(StageParse, ReasonShortRow, err)
+	}
+	projectID, err := strconv.Atoi(projectIDValue)
+	if err != nil {
+		return Event{}, newRowError(StageParse, ReasonInvalidProjectID, err)
 	}
 
 	// Parse event type
-	eventType := row[2]
+	eventType, err := row.Value(ColumnEvent)
+	if err != nil {
+		return Event{}, newRowError(StageParse, ReasonShortRow, err)
+	}
 
 	// Parse props (JSON)
+	propsValue, err := row.Value(ColumnProps)
+	if err != nil {
+		return Event{}, newRowError(StageParse, ReasonShortRow, err)
+	}
 	var props Props
-	if err := json.Unmarshal([]byte(row[14]), &props); err != nil {
-		return Event{}, err
+	if err := json.Unmarshal([]byte(propsValue), &props); err != nil {
+		return Event{}, newRowError(StageParse, ReasonInvalidProps, err)
 	}
 	currencySymbol := props.CurrencySymbol
 	currencyAddress := props.CurrencyAddress
 	chainID := props.ChainID
 
 	// Parse nums (JSON)
+	numsValue, err := row.Value(ColumnNums)
+	if err != nil {
+		return Event{}, newRowError(StageParse, ReasonShortRow, err)
+	}
 	var nums Nums
-	if err := json.Unmarshal([]byte(row[15]), &nums); err != nil {
-		return Event{}, err
+	if err := json.Unmarshal([]byte(numsValue), &nums); err != nil {
+		return Event{}, newRowError(StageParse, ReasonInvalidNums, err)
 	}
 
-	currencyValueDecimal, err := decimal.NewFromString(nums.CurrencyValueDecimal)
+	// Map currency symbol and currency address to coin ID
+	coinID, err := coinIndex.Resolve(currencySymbol, currencyAddress, chainID)
 	if err != nil {
-		return Event{}, err
+		var ambiguousErr *currency.AmbiguousCoinError
+		if errors.As(err, &ambiguousErr) {
+			return Event{}, newRowError(StageCoinMapping, ReasonAmbiguousCurrency, err)
+		}
+		return Event{}, newRowError(StageCoinMapping, ReasonUnmappedCurrency, err)
 	}
 
-	// Map currency symbol and currency address to coin ID
-	coinID, err := currency.MapCurrencyToCoinID(coins, currencySymbol, currencyAddress, chainID)
+	decimals, hasDecimals := decimalsRegistry.Lookup(coinID, chainID, currencyAddress)
+	currencyValueDecimal, err := currencyValue(nums, decimals, hasDecimals)
 	if err != nil {
 		return Event{}, err
 	}
@@ -119,7 +172,101 @@ func ParseRowToEvent(row []string, currencyUsageMap CurrencyUsageMap, mu *sync.M
 
 	UpdateCurrencyUsageMap(currencyUsageMap, mu, coinID, ts.Unix())
 
-	return NewEvent(ts, coinID, eventType, currencySymbol, projectID, currencyExchangeRate, currencyValueDecimal), nil
+	event := NewEvent(ts, coinID, eventType, currencySymbol, projectID, currencyExchangeRate, currencyValueDecimal)
+	event.ChainID = chainID
+	event.TxnHash = props.TxnHash
+	event.CurrencyValueRaw = nums.CurrencyValueRaw.String()
+	return event, nil
+}
+
+// Token amount of an event. currencyValueDecimal is used unless it is missing or
+// does not match currencyValueRaw scaled by the token decimals (e.g. native
+// tokens reported in wei in both fields), then the raw value is converted.
+func currencyValue(nums Nums, decimals int32, hasDecimals bool) (decimal.Decimal, error) {
+	var valueDecimal, valueRaw *decimal.Decimal
+	if nums.CurrencyValueDecimal != "" {
+		value, err := decimal.NewFromString(nums.CurrencyValueDecimal.String())
+		if err != nil {
+			return decimal.Decimal{}, newRowError(StageParse, ReasonInvalidCurrencyValue, err)
+		}
+		valueDecimal = &value
+	}
+	if nums.CurrencyValueRaw != "" {
+		value, err := decimal.NewFromString(nums.CurrencyValueRaw.String())
+		if err != nil {
+			return decimal.Decimal{}, newRowError(StageParse, ReasonInvalidCurrencyValue, err)
+		}
+		valueRaw = &value
+	}
+
+	if valueRaw == nil || !hasDecimals {
+		if valueDecimal == nil {
+			if valueRaw != nil {
+				return decimal.Decimal{}, newRowError(StageParse, ReasonUnknownDecimals, fmt.Errorf("no decimals known to convert raw value %s", valueRaw))
+			}
+			return decimal.Decimal{}, newRowError(StageParse, ReasonInvalidCurrencyValue, fmt.Errorf("missing currency value"))
+		}
+		return *valueDecimal, nil
+	}
+
+	fromRaw := valueRaw.Shift(-decimals)
+	if valueDecimal == nil || !withinDrift(*valueDecimal, fromRaw) {
+		return fromRaw, nil
+	}
+	return *valueDecimal, nil
+}
+
+func withinDrift(value, expected decimal.Decimal) bool {
+	diff := value.Sub(expected).Abs()
+	if expected.IsZero() {
+		return diff.IsZero()
+	}
+	return diff.Div(expected.Abs()).LessThanOrEqual(maxCurrencyValueDrift)
+}
+
+// EventRecord is a normalized event priced in a target currency, as stored in the 'events' table
+type EventRecord struct {
+	Ts                   time.Time
+	ProjectID            int
+	Event                string
+	CoinID               string
+	ChainID              string
+	TxnHash              string
+	CurrencyValueRaw     string
+	CurrencyValueDecimal decimal.Decimal
+	Currency             string
+	ExchangeRate         decimal.Decimal
+	Volume               decimal.Decimal
+	Unpriced             bool
+	UnpricedReason       string
+	RateSource           string
+}
+
+// Returns the events as priced in the target currency, call it before the events are priced in the next one
+func EventRecords(events []Event, targetCurrency string) []EventRecord {
+	records := make([]EventRecord, 0, len(events))
+	for _, event := range events {
+		record := EventRecord{
+			Ts:                   event.Ts,
+			ProjectID:            event.ProjectID,
+			Event:                event.Event,
+			CoinID:               event.CoinID,
+			ChainID:              event.ChainID,
+			TxnHash:              event.TxnHash,
+			CurrencyValueRaw:     event.CurrencyValueRaw,
+			CurrencyValueDecimal: event.CurrencyValueDecimal,
+			Currency:             targetCurrency,
+			Unpriced:             event.Unpriced,
+			UnpricedReason:       event.UnpricedReason,
+			RateSource:           event.RateSource,
+		}
+		if !event.Unpriced {
+			record.ExchangeRate = event.CurrencyExchangeRate
+			record.Volume = calculateVolume(event)
+		}
+		records = append(records, record)
+	}
+	return records
 }
 
 func CollectEvents(eventChan <-chan Event) []Event {
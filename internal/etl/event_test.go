@@ -1,6 +1,13 @@
 package etl
 
 import (
+	"bdaggregator/internal/storage/compression"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
@@ -31,3 +38,150 @@ func TestSortEventsByTimestamp(t *testing.T) {
 
 	assert.True(t, events[0].TsUnix < events[1].TsUnix, "Events should be sorted by timestamp in ascending order")
 }
+
+func TestEventRecords(t *testing.T) {
+	ts := time.Date(2024, 4, 15, 2, 15, 7, 0, time.UTC)
+	priced := NewEvent(ts, "sunflower-land", "BUY_ITEMS", "SFL", 4974, decimal.RequireFromString("0.05"), decimal.RequireFromString("2.5"))
+	priced.ChainID, priced.TxnHash, priced.CurrencyValueRaw = "137", "0xd919", "2500000000000000000"
+	priced.RateSource = "coingecko"
+	unpriced := NewEvent(ts, "sunflower-land", "BUY_ITEMS", "SFL", 4974, decimal.RequireFromString("0.04"), decimal.RequireFromString("1"))
+	unpriced.Unpriced, unpriced.UnpricedReason = true, UnpricedStaleRate
+
+	records := EventRecords([]Event{priced, unpriced}, "eur")
+	assert.Len(t, records, 2)
+
+	assert.Equal(t, "eur", records[0].Currency)
+	assert.Equal(t, "137", records[0].ChainID)
+	assert.Equal(t, "0xd919", records[0].TxnHash)
+	assert.Equal(t, "2500000000000000000", records[0].CurrencyValueRaw)
+	assert.Equal(t, "0.125", records[0].Volume.String())
+	assert.Equal(t, "coingecko", records[0].RateSource)
+
+	assert.True(t, records[1].ExchangeRate.IsZero(), "Expected no exchange rate for an unpriced event")
+	assert.True(t, records[1].Volume.IsZero())
+	assert.Equal(t, UnpricedStaleRate, records[1].UnpricedReason)
+}
+
+func TestCurrencyValue(t *testing.T) {
+	tests := []struct {
+		name           string
+		nums           Nums
+		decimals       int32
+		hasDecimals    bool
+		expectedValue  string
+		expectedReason string
+	}{
+		{
+			name:          "Consistent decimal value is kept",
+			nums:          Nums{CurrencyValueDecimal: "0.36452862533442193", CurrencyValueRaw: "364528625334421950"},
+			decimals:      18,
+			hasDecimals:   true,
+			expectedValue: "0.36452862533442193",
+		},
+		{
+			name:          "Decimal value in wei is converted from raw",
+			nums:          Nums{CurrencyValueDecimal: "700000000000000000", CurrencyValueRaw: "700000000000000000"},
+			decimals:      18,
+			hasDecimals:   true,
+			expectedValue: "0.7",
+		},
+		{
+			name:          "Missing decimal value is converted from raw",
+			nums:          Nums{CurrencyValueRaw: "5175000"},
+			decimals:      6,
+			hasDecimals:   true,
+			expectedValue: "5.175",
+		},
+		{
+			name:          "Unknown decimals keep the decimal value",
+			nums:          Nums{CurrencyValueDecimal: "5.175", CurrencyValueRaw: "5175000"},
+			expectedValue: "5.175",
+		},
+		{
+			name:           "Raw value without known decimals is rejected",
+			nums:           Nums{CurrencyValueRaw: "5175000"},
+			expectedReason: ReasonUnknownDecimals,
+		},
+		{
+			name:           "Missing values are rejected",
+			nums:           Nums{},
+			decimals:       6,
+			hasDecimals:    true,
+			expectedReason: ReasonInvalidCurrencyValue,
+		},
+		{
+			name:           "Invalid raw value is rejected",
+			nums:           Nums{CurrencyValueDecimal: "1.5", CurrencyValueRaw: "abc"},
+			expectedReason: ReasonInvalidCurrencyValue,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			value, err := currencyValue(tt.nums, tt.decimals, tt.hasDecimals)
+
+			if tt.expectedReason != "" {
+				var rowErr *RowError
+				if assert.ErrorAs(t, err, &rowErr) {
+					assert.Equal(t, tt.expectedReason, rowErr.Reason)
+				}
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, decimal.RequireFromString(tt.expectedValue).Equal(value), "expected %s, got %s", tt.expectedValue, value)
+		})
+	}
+}
+
+func readAllRows(rowReader RowReader, deadLetters *DeadLetters) (int, error) {
+	rowChan := make(chan Row, 100)
+	go func() {
+		for range rowChan {
+		}
+	}()
+	defer close(rowChan)
+	return ReadRows(rowReader, "events", rowChan, deadLetters)
+}
+
+func TestReadRows_RejectsUndecodableRows(t *testing.T) {
+	data := `{"ts":"2024-04-15 02:15:07.167","event":"BUY_ITEMS","project_id":4974}
+not json
+{"ts":"2024-04-15 03:00:00.000","event":"BUY_ITEMS","project_id":1}
+`
+	deadLetters := NewDeadLetters(nil)
+	rowCount, err := readAllRows(NewNDJSONReader(strings.NewReader(data)), deadLetters)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rowCount)
+	assert.Equal(t, map[string]int{"read/invalid_row": 1}, deadLetters.Counts())
+}
+
+func TestReadRows_OversizedLine(t *testing.T) {
+	data := `{"ts":"2024-04-15 02:15:07.167","event":"BUY_ITEMS","project_id":4974}
+{"props":"` + strings.Repeat("x", 2*maxNDJSONLineSize) + `"}
+{"ts":"2024-04-15 03:00:00.000","event":"BUY_ITEMS","project_id":1}
+`
+	deadLetters := NewDeadLetters(nil)
+	rowCount, err := readAllRows(NewNDJSONReader(strings.NewReader(data)), deadLetters)
+	assert.True(t, errors.Is(err, bufio.ErrTooLong), "Expected the oversized line to end the object, got %v", err)
+	assert.Equal(t, 1, rowCount)
+	assert.Empty(t, deadLetters.Counts(), "Expected read errors not to be dead-lettered")
+}
+
+func TestReadRows_CorruptCompressedInput(t *testing.T) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	writer.Write([]byte(csvHeader + "\n" + strings.Repeat(`"seq-market","2024-04-15 02:15:07.167","BUY_ITEMS","4974","","1","u1","s1","DE","desktop","linux","x86_64","chrome","122.0.0.0","{}","{}"`+"\n", 1000)))
+	writer.Close()
+	truncated := buf.Bytes()[:buf.Len()/2]
+
+	reader, err := compression.NewReader(io.NopCloser(bytes.NewReader(truncated)), "events.csv.gz", "")
+	assert.NoError(t, err)
+	defer reader.Close()
+	rowReader, err := NewRowReader(FormatCSV, reader)
+	assert.NoError(t, err)
+
+	deadLetters := NewDeadLetters(nil)
+	_, err = readAllRows(rowReader, deadLetters)
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF), "Expected the truncated stream to end the object, got %v", err)
+	assert.Empty(t, deadLetters.Counts(), "Expected read errors not to be dead-lettered")
+}
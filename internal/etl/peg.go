@@ -0,0 +1,122 @@
+package etl
+
+import (
+	"bdaggregator/internal/currency"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Modes of applying the peg table in GetExchangeRates
+const (
+	PegModeFixed = "fixed" // pegged assets are priced at the peg without calling the providers
+	PegModeCheck = "check" // priced at the peg, the providers' rates are fetched to flag deviating days
+	PegModeOff   = "off"   // the table is ignored, pegs are served only by the "peg" provider
+)
+
+var defaultPegTolerance = decimal.New(1, -2)
+
+// PegPolicy prices pegged assets from the peg table instead of the rate providers
+type PegPolicy struct {
+	Pegs      currency.PegTable
+	Mode      string
+	Tolerance decimal.Decimal // largest accepted deviation from the peg, relative to it
+}
+
+// Parses SEQUENCE_RATE_PEGS, SEQUENCE_PEG_MODE and SEQUENCE_PEG_TOLERANCE,
+// defaults to the fixed mode with a 1% tolerance
+func NewPegPolicy(pegs, mode, tolerance string) (PegPolicy, error) {
+	policy := PegPolicy{Mode: strings.ToLower(mode), Tolerance: defaultPegTolerance}
+	switch policy.Mode {
+	case "":
+		policy.Mode = PegModeFixed
+	case PegModeFixed, PegModeCheck, PegModeOff:
+	default:
+		return PegPolicy{}, fmt.Errorf("unsupported peg mode: %s", mode)
+	}
+
+	if tolerance != "" {
+		parsed, err := decimal.NewFromString(tolerance)
+		if err != nil || parsed.IsNegative() {
+			return PegPolicy{}, fmt.Errorf("invalid peg tolerance: %q", tolerance)
+		}
+		policy.Tolerance = parsed
+	}
+
+	table, err := currency.ParsePegs(pegs)
+	if err != nil {
+		return PegPolicy{}, err
+	}
+	policy.Pegs = table
+	return policy, nil
+}
+
+// Returns the pegged rate of the coin unless the table is turned off
+func (p PegPolicy) rate(coinID, targetCurrency string) (decimal.Decimal, bool) {
+	if p.Mode == PegModeOff {
+		return decimal.Zero, false
+	}
+	return p.Pegs.Rate(coinID, targetCurrency)
+}
+
+// PegDeviation is a day when the observed rate of a pegged asset strayed from the peg beyond the tolerance
+type PegDeviation struct {
+	CoinID    string
+	Currency  string
+	Day       string
+	Peg       decimal.Decimal
+	Observed  decimal.Decimal // the furthest rate of the day
+	Deviation decimal.Decimal // relative to the peg
+}
+
+type PegDeviations []PegDeviation
+
+// Returns the days of the observed rates that deviate from the peg by more than the tolerance
+func checkPeg(coinID, targetCurrency string, peg decimal.Decimal, observed map[int64]decimal.Decimal, tolerance decimal.Decimal) PegDeviations {
+	worst := make(map[string]PegDeviation)
+	for timestamp, rate := range observed {
+		deviation := rate.Sub(peg).Abs().Div(peg)
+		if deviation.LessThanOrEqual(tolerance) {
+			continue
+		}
+
+		day := time.Unix(timestamp, 0).UTC().Format("2006-01-02")
+		if existing, exists := worst[day]; exists && existing.Deviation.GreaterThanOrEqual(deviation) {
+			continue
+		}
+		worst[day] = PegDeviation{CoinID: coinID, Currency: targetCurrency, Day: day, Peg: peg, Observed: rate, Deviation: deviation}
+	}
+
+	deviations := make(PegDeviations, 0, len(worst))
+	for _, deviation := range worst {
+		deviations = append(deviations, deviation)
+	}
+	deviations.sort()
+	return deviations
+}
+
+func (d PegDeviations) sort() {
+	sort.Slice(d, func(i, j int) bool {
+		if d[i].CoinID != d[j].CoinID {
+			return d[i].CoinID < d[j].CoinID
+		}
+		return d[i].Day < d[j].Day
+	})
+}
+
+// Logs the days when pegged assets deviated from their peg, their events are still priced at the peg
+func (d PegDeviations) LogSummary() {
+	if len(d) == 0 {
+		return
+	}
+
+	log.Printf("Pegged assets off their peg on %d days, priced at the peg anyway:", len(d))
+	for _, deviation := range d {
+		log.Printf("  %s/%s on %s: observed %s, peg %s (%s%% off)", deviation.CoinID, deviation.Currency, deviation.Day,
+			deviation.Observed, deviation.Peg, deviation.Deviation.Shift(2).StringFixed(2))
+	}
+}
@@ -0,0 +1,150 @@
+package etl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves fixed rates per coin and records which coins were requested
+type recordingRateProvider struct {
+	mu        sync.Mutex
+	rates     map[string]map[int64]decimal.Decimal
+	requested []string
+}
+
+func (p *recordingRateProvider) Name() string {
+	return "recording"
+}
+
+func (p *recordingRateProvider) FetchRates(ctx context.Context, coinID, targetCurrency string, from, to int64) (map[int64]decimal.Decimal, error) {
+	p.mu.Lock()
+	p.requested = append(p.requested, coinID+"/"+targetCurrency)
+	p.mu.Unlock()
+
+	rates, exists := p.rates[coinID]
+	if !exists {
+		return nil, fmt.Errorf("no rates for coin: %s", coinID)
+	}
+	return rates, nil
+}
+
+func TestNewPegPolicy(t *testing.T) {
+	policy, err := NewPegPolicy("usd-coin:usd=1", "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, PegModeFixed, policy.Mode)
+	assert.Equal(t, "0.01", policy.Tolerance.String())
+
+	policy, err = NewPegPolicy("", "Check", "0.005")
+	assert.NoError(t, err)
+	assert.Equal(t, PegModeCheck, policy.Mode)
+	assert.Equal(t, "0.005", policy.Tolerance.String())
+
+	_, err = NewPegPolicy("", "strict", "")
+	assert.EqualError(t, err, "unsupported peg mode: strict")
+
+	_, err = NewPegPolicy("", "", "-0.1")
+	assert.EqualError(t, err, `invalid peg tolerance: "-0.1"`)
+
+	_, err = NewPegPolicy("usd-coin=1", "", "")
+	assert.Error(t, err)
+}
+
+func TestGetExchangeRates_PeggedAssetsSkipTheProvider(t *testing.T) {
+	day := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	provider := &recordingRateProvider{rates: map[string]map[int64]decimal.Decimal{
+		"bitcoin": {day.Unix(): decimal.NewFromInt(70000)},
+	}}
+	pegs, err := NewPegPolicy("usd-coin:usd=1", PegModeFixed, "")
+	assert.NoError(t, err)
+
+	currencyUsageMap := CurrencyUsageMap{
+		"bitcoin":  {From: day.Unix(), To: day.Unix()},
+		"usd-coin": {From: day.Unix(), To: day.AddDate(0, 0, 30).Unix()},
+	}
+	exchangeRates, deviations, err := GetExchangeRates(context.Background(), currencyUsageMap, "usd", provider, pegs, false)
+	assert.NoError(t, err)
+	assert.Empty(t, deviations)
+	assert.Equal(t, []string{"bitcoin/usd"}, provider.requested)
+
+	// A pegged rate is valid however far the event is from the ends of the range
+	matching := RateMatching{Strategy: RateStrategyNearest, MaxDistance: time.Hour}
+	rate, _, _, reason := matching.match(exchangeRates["usd-coin"], day.AddDate(0, 0, 15).Unix())
+	assert.Empty(t, reason)
+	assert.Equal(t, "1", rate.String())
+
+	// Pegs apply only to their target currency
+	provider.requested = nil
+	_, _, err = GetExchangeRates(context.Background(), CurrencyUsageMap{"usd-coin": currencyUsageMap["usd-coin"]}, "eur", provider, pegs, false)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"usd-coin/eur"}, provider.requested)
+
+	// The off mode leaves pegs to the "peg" provider
+	pegs.Mode = PegModeOff
+	provider.requested = nil
+	_, _, err = GetExchangeRates(context.Background(), CurrencyUsageMap{"usd-coin": currencyUsageMap["usd-coin"]}, "usd", provider, pegs, false)
+	assert.Error(t, err)
+	assert.Equal(t, []string{"usd-coin/usd"}, provider.requested)
+}
+
+func TestGetExchangeRates_PeggedAndFetchedCoins(t *testing.T) {
+	day := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC).Unix()
+	provider := &recordingRateProvider{rates: make(map[string]map[int64]decimal.Decimal)}
+	var pegList []string
+	currencyUsageMap := make(CurrencyUsageMap)
+	for i := 0; i < 50; i++ {
+		fetched, pegged := fmt.Sprintf("coin-%d", i), fmt.Sprintf("stable-%d", i)
+		provider.rates[fetched] = map[int64]decimal.Decimal{day: decimal.NewFromInt(int64(i + 1))}
+		pegList = append(pegList, pegged+":usd=1")
+		currencyUsageMap[fetched] = CurrencyUsage{From: day, To: day}
+		currencyUsageMap[pegged] = CurrencyUsage{From: day, To: day}
+	}
+	pegs, err := NewPegPolicy(strings.Join(pegList, ","), PegModeFixed, "")
+	assert.NoError(t, err)
+
+	// Pegged series are stored while the fetches of other coins are running, run with -race
+	exchangeRates, _, err := GetExchangeRates(context.Background(), currencyUsageMap, "usd", provider, pegs, false)
+	assert.NoError(t, err)
+	assert.Len(t, exchangeRates, 100)
+	assert.True(t, exchangeRates["stable-0"].pegged)
+	assertRate(t, exchangeRates["coin-49"], day, "50")
+}
+
+func TestGetExchangeRates_PegCheck(t *testing.T) {
+	day := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	provider := &recordingRateProvider{rates: map[string]map[int64]decimal.Decimal{
+		"usd-coin": {
+			day.Unix():                                     decimal.RequireFromString("0.999"),
+			day.Add(time.Hour).Unix():                      decimal.RequireFromString("1.005"),
+			day.AddDate(0, 0, 1).Unix():                    decimal.RequireFromString("0.985"),
+			day.AddDate(0, 0, 1).Add(6 * time.Hour).Unix(): decimal.RequireFromString("0.97"),
+		},
+	}}
+	pegs, err := NewPegPolicy("usd-coin:usd=1,tether:usd=1", PegModeCheck, "0.01")
+	assert.NoError(t, err)
+
+	currencyUsageMap := CurrencyUsageMap{
+		"usd-coin": {From: day.Unix(), To: day.AddDate(0, 0, 2).Unix()},
+		"tether":   {From: day.Unix(), To: day.AddDate(0, 0, 2).Unix()},
+	}
+	exchangeRates, deviations, err := GetExchangeRates(context.Background(), currencyUsageMap, "usd", provider, pegs, false)
+	assert.NoError(t, err, "Expected a failed peg check not to fail the run")
+	assert.ElementsMatch(t, []string{"usd-coin/usd", "tether/usd"}, provider.requested)
+
+	rate, _, _, reason := RateMatching{Strategy: RateStrategyNearest}.match(exchangeRates["usd-coin"], day.AddDate(0, 0, 1).Unix())
+	assert.Empty(t, reason)
+	assert.Equal(t, "1", rate.String(), "Expected pegged assets to be priced at the peg in the check mode")
+
+	if assert.Len(t, deviations, 1) {
+		assert.Equal(t, "usd-coin", deviations[0].CoinID)
+		assert.Equal(t, "2024-04-02", deviations[0].Day)
+		assert.Equal(t, "0.97", deviations[0].Observed.String())
+		assert.Equal(t, "0.03", deviations[0].Deviation.String())
+	}
+}
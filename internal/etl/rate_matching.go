@@ -0,0 +1,226 @@
+package etl
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Strategies for picking the exchange rate of an event
+const (
+	RateStrategyNearest        = "nearest"         // the closest rate in time, before or after the event
+	RateStrategyPrevious       = "previous"        // the latest rate at or before the event
+	RateStrategyLinear         = "linear"          // interpolated between the rates around the event
+	RateStrategyDailyClose     = "daily_close"     // the last rate of the event's UTC day
+	RateStrategyVolumeWeighted = "volume_weighted" // the rates of the event's UTC day weighted by the rolling 24h volume, an approximation of a VWAP
+)
+
+// Reasons for leaving an event unpriced
+const (
+	UnpricedNoRates   = "no_rates"
+	UnpricedStaleRate = "stale_rate"
+	UnpricedNoVolumes = "no_volumes"
+)
+
+const secondsPerDay = 24 * 60 * 60
+
+// RateMatching selects the rate of an event with a strategy, a rate further
+// than MaxDistance from the event (0 means no limit) leaves the event unpriced
+type RateMatching struct {
+	Strategy    string
+	MaxDistance time.Duration
+}
+
+// Parses SEQUENCE_RATE_STRATEGY and SEQUENCE_RATE_MAX_DISTANCE, defaults to the nearest rate without a limit
+func NewRateMatching(strategy, maxDistance string) (RateMatching, error) {
+	matching := RateMatching{Strategy: strings.ToLower(strategy)}
+	switch matching.Strategy {
+	case "":
+		matching.Strategy = RateStrategyNearest
+	case RateStrategyNearest, RateStrategyPrevious, RateStrategyLinear, RateStrategyDailyClose, RateStrategyVolumeWeighted:
+	default:
+		return RateMatching{}, fmt.Errorf("unsupported rate strategy: %s", strategy)
+	}
+
+	if maxDistance != "" {
+		distance, err := time.ParseDuration(maxDistance)
+		if err != nil || distance < 0 {
+			return RateMatching{}, fmt.Errorf("invalid rate max distance: %q", maxDistance)
+		}
+		matching.MaxDistance = distance
+	}
+	return matching, nil
+}
+
+// The volume-weighted average needs the traded volumes fetched with the rates
+func (m RateMatching) NeedsVolumes() bool {
+	return m.Strategy == RateStrategyVolumeWeighted
+}
+
+// Returns the rate for the timestamp with the timestamps of the rates it was taken or
+// interpolated from (zero for a pegged rate), or the reason why the event can't be priced
+func (m RateMatching) match(rates RateSeries, tsUnix int64) (rate decimal.Decimal, rateFrom, rateTo int64, reason string) {
+	if rates.Len() == 0 {
+		return decimal.Zero, 0, 0, UnpricedNoRates
+	}
+	if rates.pegged {
+		return rates.rates[0], 0, 0, ""
+	}
+	if m.Strategy == RateStrategyVolumeWeighted {
+		return rates.volumeWeighted(dayStart(tsUnix))
+	}
+
+	target := tsUnix
+	if m.Strategy == RateStrategyDailyClose {
+		// CoinGecko stamps daily closes at 00:00 UTC of the next day
+		target = dayStart(tsUnix) + secondsPerDay
+	}
+	prevTs, prevRate, hasPrev, nextTs, nextRate, hasNext := rates.surrounding(target)
+
+	var distance int64
+	switch {
+	case m.Strategy == RateStrategyPrevious || m.Strategy == RateStrategyDailyClose:
+		if !hasPrev {
+			return decimal.Zero, 0, 0, UnpricedNoRates
+		}
+		rate, rateFrom, rateTo, distance = prevRate, prevTs, prevTs, target-prevTs
+	case m.Strategy == RateStrategyLinear && hasPrev && hasNext && prevTs != nextTs:
+		// Multiplied before dividing to keep the result exact whenever possible
+		delta := nextRate.Sub(prevRate).Mul(decimal.NewFromInt(target - prevTs))
+		rate = prevRate.Add(delta.Div(decimal.NewFromInt(nextTs - prevTs)))
+		rateFrom, rateTo, distance = prevTs, nextTs, max(target-prevTs, nextTs-target)
+	case !hasNext || hasPrev && target-prevTs <= nextTs-target:
+		rate, rateFrom, rateTo, distance = prevRate, prevTs, prevTs, target-prevTs
+	default:
+		rate, rateFrom, rateTo, distance = nextRate, nextTs, nextTs, nextTs-target
+	}
+
+	if m.MaxDistance > 0 && time.Duration(distance)*time.Second > m.MaxDistance {
+		return decimal.Zero, 0, 0, UnpricedStaleRate
+	}
+	return rate, rateFrom, rateTo, ""
+}
+
+// Averages the rates of the UTC day weighted by their volumes. CoinGecko reports a rolling
+// 24h volume with each rate, not the volume traded since the previous one, so this only
+// approximates a VWAP: rates after heavy trading weigh more. CoinGecko stamps a daily rate
+// at 00:00 UTC of the next day, so the day runs from after its midnight up to the next one.
+// Only the day's own rates are used, the max distance does not apply.
+func (s RateSeries) volumeWeighted(dayStart int64) (rate decimal.Decimal, rateFrom, rateTo int64, reason string) {
+	first, _ := slices.BinarySearch(s.timestamps, dayStart+1)
+	last, _ := slices.BinarySearch(s.timestamps, dayStart+secondsPerDay+1)
+	if first == last {
+		return decimal.Zero, 0, 0, UnpricedNoRates
+	}
+	if s.volumes == nil {
+		return decimal.Zero, 0, 0, UnpricedNoVolumes
+	}
+
+	weighted, total := decimal.Zero, decimal.Zero
+	for i := first; i < last; i++ {
+		weighted = weighted.Add(s.rates[i].Mul(s.volumes[i]))
+		total = total.Add(s.volumes[i])
+	}
+	if !total.IsPositive() {
+		return decimal.Zero, 0, 0, UnpricedNoVolumes
+	}
+	return weighted.Div(total), s.timestamps[first], s.timestamps[last-1], ""
+}
+
+func dayStart(tsUnix int64) int64 {
+	return tsUnix - ((tsUnix%secondsPerDay)+secondsPerDay)%secondsPerDay
+}
+
+// Widens the usage ranges so rates around the first and last events are fetched too,
+// by the max distance or a day without a limit, and up to the daily close of the last day.
+// The volume-weighted average needs the whole days of the events and nothing around them.
+func (m RateMatching) FetchRanges(currencyUsageMap CurrencyUsageMap) CurrencyUsageMap {
+	padding := int64(secondsPerDay)
+	if m.MaxDistance > 0 {
+		padding = int64(m.MaxDistance / time.Second)
+	}
+
+	ranges := make(CurrencyUsageMap, len(currencyUsageMap))
+	for coinID, usage := range currencyUsageMap {
+		switch m.Strategy {
+		case RateStrategyVolumeWeighted:
+			ranges[coinID] = CurrencyUsage{From: dayStart(usage.From), To: dayStart(usage.To) + secondsPerDay}
+		case RateStrategyDailyClose:
+			ranges[coinID] = CurrencyUsage{From: usage.From - padding, To: dayStart(usage.To) + secondsPerDay + padding}
+		default:
+			ranges[coinID] = CurrencyUsage{From: usage.From - padding, To: usage.To + padding}
+		}
+	}
+	return ranges
+}
+
+// PricingReport counts the events left unpriced per coin and reason
+type PricingReport struct {
+	Priced   int
+	Unpriced map[string]map[string]int
+}
+
+func NewPricingReport() *PricingReport {
+	return &PricingReport{Unpriced: make(map[string]map[string]int)}
+}
+
+func (r *PricingReport) record(event Event) {
+	if !event.Unpriced {
+		r.Priced++
+		return
+	}
+	if _, exists := r.Unpriced[event.CoinID]; !exists {
+		r.Unpriced[event.CoinID] = make(map[string]int)
+	}
+	r.Unpriced[event.CoinID][event.UnpricedReason]++
+}
+
+func (r *PricingReport) merge(other *PricingReport) {
+	r.Priced += other.Priced
+	for coinID, reasons := range other.Unpriced {
+		if _, exists := r.Unpriced[coinID]; !exists {
+			r.Unpriced[coinID] = make(map[string]int)
+		}
+		for reason, count := range reasons {
+			r.Unpriced[coinID][reason] += count
+		}
+	}
+}
+
+func (r *PricingReport) UnpricedCount() int {
+	total := 0
+	for _, reasons := range r.Unpriced {
+		for _, count := range reasons {
+			total += count
+		}
+	}
+	return total
+}
+
+// Logs the number of unpriced events per coin and reason, they are excluded from the volume totals
+func (r *PricingReport) LogSummary() {
+	total := r.UnpricedCount()
+	if total == 0 {
+		log.Printf("All %d events priced", r.Priced)
+		return
+	}
+
+	keys := make([]string, 0, len(r.Unpriced))
+	for coinID, reasons := range r.Unpriced {
+		for reason := range reasons {
+			keys = append(keys, coinID+"/"+reason)
+		}
+	}
+	sort.Strings(keys)
+
+	log.Printf("Priced %d events, %d events unpriced and excluded from volumes:", r.Priced, total)
+	for _, key := range keys {
+		coinID, reason, _ := strings.Cut(key, "/")
+		log.Printf("  %s: %d", key, r.Unpriced[coinID][reason])
+	}
+}
@@ -0,0 +1,188 @@
+package etl
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRateMatching(t *testing.T) {
+	matching, err := NewRateMatching("", "")
+	assert.NoError(t, err)
+	assert.Equal(t, RateMatching{Strategy: RateStrategyNearest}, matching)
+
+	matching, err = NewRateMatching("Daily_Close", "36h")
+	assert.NoError(t, err)
+	assert.Equal(t, RateMatching{Strategy: RateStrategyDailyClose, MaxDistance: 36 * time.Hour}, matching)
+
+	matching, err = NewRateMatching("Volume_Weighted", "")
+	assert.NoError(t, err)
+	assert.True(t, matching.NeedsVolumes())
+
+	_, err = NewRateMatching("twap", "")
+	assert.EqualError(t, err, "unsupported rate strategy: twap")
+
+	_, err = NewRateMatching("nearest", "1 day")
+	assert.EqualError(t, err, `invalid rate max distance: "1 day"`)
+}
+
+func TestRateMatching_Strategies(t *testing.T) {
+	day := time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC).Unix()
+	rates := NewRateSeries(map[int64]decimal.Decimal{
+		day:             decimal.RequireFromString("1.00"),
+		day + 3600:      decimal.RequireFromString("1.10"),
+		day + 4*3600:    decimal.RequireFromString("1.40"),
+		day + 24*3600:   decimal.RequireFromString("2.00"),
+		day + 2*24*3600: decimal.RequireFromString("3.00"),
+	})
+
+	tests := []struct {
+		name           string
+		strategy       string
+		maxDistance    time.Duration
+		ts             int64
+		expectedRate   string
+		expectedReason string
+	}{
+		{name: "Nearest picks the closer later rate", strategy: RateStrategyNearest, ts: day + 3*3600, expectedRate: "1.4"},
+		{name: "Nearest picks the closer earlier rate", strategy: RateStrategyNearest, ts: day + 3600 + 60, expectedRate: "1.1"},
+		{name: "Nearest exact match", strategy: RateStrategyNearest, ts: day + 3600, expectedRate: "1.1"},
+		{name: "Previous never looks ahead", strategy: RateStrategyPrevious, ts: day + 3*3600 + 3500, expectedRate: "1.1"},
+		{name: "Previous without earlier rates", strategy: RateStrategyPrevious, ts: day - 60, expectedReason: UnpricedNoRates},
+		{name: "Linear interpolates", strategy: RateStrategyLinear, ts: day + 2*3600, expectedRate: "1.2"},
+		{name: "Linear at a rate", strategy: RateStrategyLinear, ts: day + 4*3600, expectedRate: "1.4"},
+		{name: "Linear after the last rate", strategy: RateStrategyLinear, ts: day + 3*24*3600, expectedRate: "3"},
+		{name: "Daily close uses the next midnight", strategy: RateStrategyDailyClose, ts: day + 3600, expectedRate: "2"},
+		{name: "Daily close of the last day", strategy: RateStrategyDailyClose, ts: day + 2*24*3600 + 60, expectedRate: "3"},
+		{name: "Stale nearest rate", strategy: RateStrategyNearest, maxDistance: time.Hour, ts: day + 10*3600, expectedReason: UnpricedStaleRate},
+		{name: "Nearest rate within the limit", strategy: RateStrategyNearest, maxDistance: time.Hour, ts: day + 5*3600, expectedRate: "1.4"},
+		{name: "Stale interpolation", strategy: RateStrategyLinear, maxDistance: 6 * time.Hour, ts: day + 5*3600, expectedReason: UnpricedStaleRate},
+		{name: "Stale daily close", strategy: RateStrategyDailyClose, maxDistance: time.Hour, ts: day + 3*24*3600, expectedReason: UnpricedStaleRate},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matching := RateMatching{Strategy: tt.strategy, MaxDistance: tt.maxDistance}
+			rate, _, _, reason := matching.match(rates, tt.ts)
+			assert.Equal(t, tt.expectedReason, reason)
+			if tt.expectedReason == "" {
+				assert.Equal(t, tt.expectedRate, rate.String())
+			} else {
+				assert.True(t, rate.IsZero())
+			}
+		})
+	}
+
+	_, _, _, reason := RateMatching{Strategy: RateStrategyNearest}.match(RateSeries{}, day)
+	assert.Equal(t, UnpricedNoRates, reason)
+}
+
+func TestRateMatching_VolumeWeighted(t *testing.T) {
+	day := time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC).Unix()
+	rates := map[int64]decimal.Decimal{
+		day:                   decimal.RequireFromString("5.00"), // the previous day's daily rate
+		day + 3600:            decimal.RequireFromString("1.00"),
+		day + 2*3600:          decimal.RequireFromString("2.00"),
+		day + secondsPerDay:   decimal.RequireFromString("4.00"),
+		day + 3*secondsPerDay: decimal.RequireFromString("3.00"),
+	}
+	volumes := map[int64]decimal.Decimal{
+		day:                 decimal.NewFromInt(100),
+		day + 3600:          decimal.NewFromInt(30),
+		day + 2*3600:        decimal.NewFromInt(10),
+		day + secondsPerDay: decimal.NewFromInt(10),
+	}
+	matching := RateMatching{Strategy: RateStrategyVolumeWeighted, MaxDistance: time.Minute}
+
+	rate, rateFrom, rateTo, reason := matching.match(NewRateSeriesWithVolumes(rates, volumes), day+12*3600)
+	assert.Empty(t, reason)
+	assert.Equal(t, "1.8", rate.String(), "Expected (1*30 + 2*10 + 4*10) / 50")
+	assert.Equal(t, []int64{day + 3600, day + secondsPerDay}, []int64{rateFrom, rateTo})
+
+	_, _, _, reason = matching.match(NewRateSeriesWithVolumes(rates, volumes), day+2*secondsPerDay+60)
+	assert.Equal(t, UnpricedNoVolumes, reason, "Expected a day without traded volume to be unpriced")
+
+	_, _, _, reason = matching.match(NewRateSeriesWithVolumes(rates, volumes), day+3*secondsPerDay+60)
+	assert.Equal(t, UnpricedNoRates, reason)
+
+	_, _, _, reason = matching.match(NewRateSeries(rates), day+12*3600)
+	assert.Equal(t, UnpricedNoVolumes, reason, "Expected rates without volumes to be unpriced")
+}
+
+func TestRateMatching_RateTimestamps(t *testing.T) {
+	day := time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC).Unix()
+	rates := NewRateSeries(map[int64]decimal.Decimal{
+		day:        decimal.RequireFromString("1.00"),
+		day + 3600: decimal.RequireFromString("1.10"),
+	})
+
+	_, rateFrom, rateTo, _ := RateMatching{Strategy: RateStrategyNearest}.match(rates, day+2400)
+	assert.Equal(t, []int64{day + 3600, day + 3600}, []int64{rateFrom, rateTo})
+
+	_, rateFrom, rateTo, _ = RateMatching{Strategy: RateStrategyLinear}.match(rates, day+2400)
+	assert.Equal(t, []int64{day, day + 3600}, []int64{rateFrom, rateTo}, "Expected both rates of an interpolation")
+
+	_, rateFrom, rateTo, _ = RateMatching{Strategy: RateStrategyNearest}.match(newPeggedRateSeries(decimal.NewFromInt(1), day, day+3600), day)
+	assert.Equal(t, []int64{0, 0}, []int64{rateFrom, rateTo}, "Expected no timestamps for a pegged rate")
+}
+
+func TestUpdateExchangeRates(t *testing.T) {
+	day := time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC)
+	exchangeRates := FetchedExchangeRates{
+		"matic-network": NewRateSeries(map[int64]decimal.Decimal{day.Unix(): decimal.RequireFromString("0.7")}),
+	}
+	series := exchangeRates["matic-network"]
+	series.source = "file"
+	exchangeRates["matic-network"] = series
+	events := []Event{
+		NewEvent(day.Add(30*time.Minute), "matic-network", "BUY_ITEMS", "MATIC", 1, decimal.Zero, decimal.NewFromInt(10)),
+		NewEvent(day.Add(5*time.Hour), "matic-network", "BUY_ITEMS", "MATIC", 1, decimal.Zero, decimal.NewFromInt(10)),
+		NewEvent(day.Add(time.Hour), "unknown-coin", "BUY_ITEMS", "UNK", 1, decimal.Zero, decimal.NewFromInt(10)),
+	}
+
+	report := UpdateExchangeRates(events, exchangeRates, RateMatching{Strategy: RateStrategyNearest, MaxDistance: time.Hour})
+
+	assert.False(t, events[0].Unpriced)
+	assert.Equal(t, "0.7", events[0].CurrencyExchangeRate.String())
+	assert.Equal(t, "file", events[0].RateSource)
+	assert.Equal(t, day.Unix(), events[0].RateFrom)
+	assert.Empty(t, events[1].RateSource, "Expected no provenance for an unpriced event")
+	assert.True(t, events[1].Unpriced)
+	assert.Equal(t, UnpricedStaleRate, events[1].UnpricedReason)
+	assert.True(t, events[2].Unpriced)
+	assert.Equal(t, UnpricedNoRates, events[2].UnpricedReason)
+
+	assert.Equal(t, 1, report.Priced)
+	assert.Equal(t, 2, report.UnpricedCount())
+	assert.Equal(t, map[string]map[string]int{
+		"matic-network": {UnpricedStaleRate: 1},
+		"unknown-coin":  {UnpricedNoRates: 1},
+	}, report.Unpriced)
+
+	// Unpriced events count as transactions but not towards the volume
+	aggregated := AggregateEvents(events, "usd")
+	assert.Len(t, aggregated, 1)
+	assert.Equal(t, 3, aggregated[0].NumberOfTransactionsPerProject)
+	assert.Equal(t, "7", aggregated[0].TotalVolumePerProject.String())
+}
+
+func TestRateMatching_FetchRanges(t *testing.T) {
+	day := time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC).Unix()
+	usage := CurrencyUsageMap{"bitcoin": {From: day + 3600, To: day + 7200}}
+
+	ranges := RateMatching{Strategy: RateStrategyNearest}.FetchRanges(usage)
+	assert.Equal(t, CurrencyUsage{From: day + 3600 - secondsPerDay, To: day + 7200 + secondsPerDay}, ranges["bitcoin"])
+
+	ranges = RateMatching{Strategy: RateStrategyPrevious, MaxDistance: time.Hour}.FetchRanges(usage)
+	assert.Equal(t, CurrencyUsage{From: day, To: day + 3*3600}, ranges["bitcoin"])
+
+	ranges = RateMatching{Strategy: RateStrategyDailyClose, MaxDistance: time.Hour}.FetchRanges(usage)
+	assert.Equal(t, CurrencyUsage{From: day, To: day + secondsPerDay + 3600}, ranges["bitcoin"])
+
+	ranges = RateMatching{Strategy: RateStrategyVolumeWeighted, MaxDistance: time.Hour}.FetchRanges(usage)
+	assert.Equal(t, CurrencyUsage{From: day, To: day + secondsPerDay}, ranges["bitcoin"])
+
+	assert.Equal(t, CurrencyUsage{From: day + 3600, To: day + 7200}, usage["bitcoin"], "Expected the usage map to be left unchanged")
+}
@@ -0,0 +1,133 @@
+package compression
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type Format string
+
+const (
+	None  Format = ""
+	Gzip  Format = "gzip"
+	Zstd  Format = "zstd"
+	Bzip2 Format = "bzip2"
+)
+
+var extensions = map[string]Format{
+	".gz":   Gzip,
+	".gzip": Gzip,
+	".zst":  Zstd,
+	".zstd": Zstd,
+	".bz2":  Bzip2,
+}
+
+var magicBytes = map[Format][]byte{
+	Gzip:  {0x1f, 0x8b},
+	Zstd:  {0x28, 0xb5, 0x2f, 0xfd},
+	Bzip2: []byte("BZh"),
+}
+
+// Wrap an object reader with a decompressor. The format is taken from the
+// object metadata (Content-Encoding) when available, then from the file
+// extension and finally from the magic bytes at the start of the stream.
+// Closing the returned reader closes the decompressor and the underlying object reader.
+func NewReader(reader io.ReadCloser, name, contentEncoding string) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(reader)
+
+	format := FromContentEncoding(contentEncoding)
+	if format == None {
+		format = FromName(name)
+	}
+	if format == None {
+		format = fromMagicBytes(buffered)
+	}
+
+	var decoder io.ReadCloser
+	switch format {
+	case Gzip:
+		gzipReader, err := gzip.NewReader(buffered)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		decoder = gzipReader
+	case Zstd:
+		zstdReader, err := zstd.NewReader(buffered)
+		if err != nil {
+			reader.Close()
+			return nil, err
+		}
+		// IOReadCloser stops the decoder goroutines on Close
+		decoder = zstdReader.IOReadCloser()
+	case Bzip2:
+		decoder = io.NopCloser(bzip2.NewReader(buffered))
+	default:
+		decoder = io.NopCloser(buffered)
+	}
+
+	return &readCloser{decoder: decoder, object: reader}, nil
+}
+
+// Detect the compression format from an HTTP-style Content-Encoding value
+func FromContentEncoding(contentEncoding string) Format {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "gzip", "x-gzip":
+		return Gzip
+	case "zstd":
+		return Zstd
+	case "bzip2", "x-bzip2":
+		return Bzip2
+	default:
+		return None
+	}
+}
+
+// Detect the compression format from the object name extension
+func FromName(name string) Format {
+	return extensions[strings.ToLower(path.Ext(name))]
+}
+
+// Strip the compression extension, e.g. "events.csv.gz" becomes "events.csv"
+func TrimExt(name string) string {
+	if FromName(name) == None {
+		return name
+	}
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+func fromMagicBytes(reader *bufio.Reader) Format {
+	// Peek returns fewer bytes with an error for short streams, which is fine
+	header, _ := reader.Peek(4)
+	for format, magic := range magicBytes {
+		if bytes.HasPrefix(header, magic) {
+			return format
+		}
+	}
+	return None
+}
+
+type readCloser struct {
+	decoder io.ReadCloser
+	object  io.ReadCloser
+}
+
+func (r *readCloser) Read(p []byte) (int, error) {
+	return r.decoder.Read(p)
+}
+
+// Closes the object reader even when closing the decompressor fails
+func (r *readCloser) Close() error {
+	decoderErr := r.decoder.Close()
+	if err := r.object.Close(); err != nil {
+		return err
+	}
+	return decoderErr
+}
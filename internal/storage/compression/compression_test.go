@@ -0,0 +1,92 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+const expectedData = "sample data"
+
+// "sample data" compressed with bzip2, the standard library can only decompress it
+const bzip2Data = "QlpoOTFBWSZTWdIYP8MAAASRgEAAJgZMACAAIgGm1CDJiMYt4ZUeLuSKcKEhpDB/hg=="
+
+func gzipData(t *testing.T) []byte {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	_, err := writer.Write([]byte(expectedData))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func zstdData(t *testing.T) []byte {
+	var buf bytes.Buffer
+	writer, err := zstd.NewWriter(&buf)
+	assert.NoError(t, err)
+	_, err = writer.Write([]byte(expectedData))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestNewReader(t *testing.T) {
+	bzip2Bytes, err := base64.StdEncoding.DecodeString(bzip2Data)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name            string
+		data            []byte
+		objectName      string
+		contentEncoding string
+	}{
+		{"Plain", []byte(expectedData), "events.csv", ""},
+		{"Gzip by content encoding", gzipData(t), "events.csv", "gzip"},
+		{"Gzip by extension", gzipData(t), "events.csv.gz", ""},
+		{"Gzip by magic bytes", gzipData(t), "events", ""},
+		{"Zstd by extension", zstdData(t), "events.csv.zst", ""},
+		{"Zstd by magic bytes", zstdData(t), "events", ""},
+		{"Bzip2 by extension", bzip2Bytes, "events.csv.bz2", ""},
+		{"Bzip2 by magic bytes", bzip2Bytes, "events", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			object := &trackingCloser{Reader: bytes.NewReader(tt.data)}
+			reader, err := NewReader(object, tt.objectName, tt.contentEncoding)
+			assert.NoError(t, err, "Expected no error creating reader")
+
+			data, err := io.ReadAll(reader)
+			assert.NoError(t, err, "Expected no error reading data")
+			assert.Equal(t, expectedData, string(data), "Expected decompressed data")
+			assert.NoError(t, reader.Close())
+			assert.True(t, object.closed, "Expected the object reader to be closed with the decompressor")
+		})
+	}
+}
+
+type trackingCloser struct {
+	io.Reader
+	closed bool
+}
+
+func (c *trackingCloser) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestNewReader_Corrupted(t *testing.T) {
+	_, err := NewReader(io.NopCloser(bytes.NewReader([]byte(expectedData))), "events.csv.gz", "")
+	assert.Error(t, err, "Expected an error for data that is not gzip compressed")
+}
+
+func TestTrimExt(t *testing.T) {
+	assert.Equal(t, "events.csv", TrimExt("events.csv.gz"))
+	assert.Equal(t, "events.ndjson", TrimExt("events.ndjson.zst"))
+	assert.Equal(t, "events.csv", TrimExt("events.csv"))
+}
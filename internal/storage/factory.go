@@ -4,23 +4,46 @@ package storage
  * Altough for this exercise Google Cloud Storage (GCS) is the only requested
  * storage type, for testing and demo purposes, I added the ability to load
  * a file from a local path to showcase storage flexibility.
- * We could add more storage options, such as S3, Azure, etc.
+ * S3 (and S3-compatible servers such as MinIO) is supported as well.
+ * We could add more storage options, such as Azure, etc.
  */
 import (
 	"fmt"
+	"io"
 
 	"bdaggregator/internal/config"
 	"bdaggregator/internal/storage/gcs"
 	"bdaggregator/internal/storage/local"
+	"bdaggregator/internal/storage/s3"
 )
 
 func NewStorage(cfg *config.Config) (Storage, error) {
 	switch cfg.StorageType {
 	case "GCS":
 		return gcs.NewGCSStorage(cfg), nil
+	case "S3":
+		return s3.NewS3Storage(cfg), nil
 	case "local":
 		return local.NewLocalStorage(cfg), nil
 	default:
 		return nil, fmt.Errorf("unsupported storage type: %s", cfg.StorageType)
 	}
 }
+
+// Open the dead-letter output for rejected rows. It is written to a local file
+// ("local", the default) or as an object of the input storage ("storage").
+// Returns nil when no dead-letter path is configured.
+func NewDeadLetterWriter(cfg *config.Config, inputStorage Storage) (io.WriteCloser, error) {
+	if cfg.DeadLetterPath == "" {
+		return nil, nil
+	}
+
+	switch cfg.DeadLetterType {
+	case "", "local":
+		return local.NewLocalStorage(cfg).Create(cfg.DeadLetterPath)
+	case "storage":
+		return inputStorage.Create(cfg.DeadLetterPath)
+	default:
+		return nil, fmt.Errorf("unsupported dead letter type: %s", cfg.DeadLetterType)
+	}
+}
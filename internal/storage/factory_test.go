@@ -4,6 +4,9 @@ import (
 	"bdaggregator/internal/config"
 	"bdaggregator/internal/storage/gcs"
 	"bdaggregator/internal/storage/local"
+	"bdaggregator/internal/storage/s3"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -22,6 +25,12 @@ func TestNewStorage(t *testing.T) {
 			expectedType: &gcs.GCSStorage{},
 			expectError:  false,
 		},
+		{
+			name:         "S3 storage type",
+			storageType:  "S3",
+			expectedType: &s3.S3Storage{},
+			expectError:  false,
+		},
 		{
 			name:         "Local storage type",
 			storageType:  "local",
@@ -54,3 +63,25 @@ func TestNewStorage(t *testing.T) {
 		})
 	}
 }
+
+func TestNewDeadLetterWriter(t *testing.T) {
+	dir := t.TempDir()
+	inputStorage := local.NewLocalStorage(&config.Config{})
+
+	writer, err := NewDeadLetterWriter(&config.Config{}, inputStorage)
+	assert.NoError(t, err, "Expected no error when dead letters are disabled")
+	assert.Nil(t, writer, "Expected no writer without a dead letter path")
+
+	for _, deadLetterType := range []string{"", "local", "storage"} {
+		path := filepath.Join(dir, "type_"+deadLetterType, "dead_letters.ndjson")
+		writer, err := NewDeadLetterWriter(&config.Config{DeadLetterType: deadLetterType, DeadLetterPath: path}, inputStorage)
+		assert.NoError(t, err, "Expected no error for dead letter type %q", deadLetterType)
+		assert.NoError(t, writer.Close())
+
+		_, err = os.Stat(path)
+		assert.NoError(t, err, "Expected dead letter file to be created for type %q", deadLetterType)
+	}
+
+	_, err = NewDeadLetterWriter(&config.Config{DeadLetterType: "unsupported", DeadLetterPath: "x"}, inputStorage)
+	assert.Error(t, err, "Expected an error for unsupported dead letter type")
+}
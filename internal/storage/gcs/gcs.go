@@ -2,33 +2,110 @@ package gcs
 
 import (
 	"bdaggregator/internal/config"
+	"bdaggregator/internal/storage/compression"
+	"bdaggregator/internal/storage/pattern"
 	"context"
+	"fmt"
 	"io"
+	"sort"
 
 	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
 )
 
 type GCSStorage struct {
-	cfg *config.Config
+	cfg    *config.Config
+	client *storage.Client
 }
 
 func NewGCSStorage(cfg *config.Config) *GCSStorage {
 	return &GCSStorage{cfg: cfg}
 }
 
-func (g *GCSStorage) Download() (io.Reader, error) {
+func (g *GCSStorage) List() ([]string, error) {
+	objectPath := g.cfg.GCSObject
+	if !pattern.IsPattern(objectPath) {
+		return []string{objectPath}, nil
+	}
+
 	ctx := context.Background()
-	client, err := storage.NewClient(ctx)
+	client, err := g.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	it := client.Bucket(g.cfg.GCSBucket).Objects(ctx, &storage.Query{Prefix: pattern.Prefix(objectPath)})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in bucket %s: %v", g.cfg.GCSBucket, err)
+		}
+		if pattern.Match(objectPath, attrs.Name) {
+			names = append(names, attrs.Name)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no objects found matching %s in bucket %s", objectPath, g.cfg.GCSBucket)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (g *GCSStorage) Open(name string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	client, err := g.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	reader, err := client.Bucket(g.cfg.GCSBucket).Object(name).NewReader(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	bucket := client.Bucket(g.cfg.GCSBucket)
-	obj := bucket.Object(g.cfg.GCSObject)
-	reader, err := obj.NewReader(ctx)
+	// GCS transcodes objects stored with Content-Encoding: gzip on download,
+	// such data is already decompressed whatever the object name says
+	if reader.Attrs.Decompressed {
+		return reader, nil
+	}
+
+	return compression.NewReader(reader, name, reader.Attrs.ContentEncoding)
+}
+
+func (g *GCSStorage) Create(name string) (io.WriteCloser, error) {
+	ctx := context.Background()
+	client, err := g.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Bucket(g.cfg.GCSBucket).Object(name).NewWriter(ctx), nil
+}
+
+func (g *GCSStorage) Close() error {
+	if g.client == nil {
+		return nil
+	}
+	return g.client.Close()
+}
+
+// The client is created on first use and shared by all listed objects
+func (g *GCSStorage) getClient(ctx context.Context) (*storage.Client, error) {
+	if g.client != nil {
+		return g.client, nil
+	}
+
+	client, err := storage.NewClient(ctx)
 	if err != nil {
 		return nil, err
 	}
+	g.client = client
 
-	return reader, nil
+	return client, nil
 }
@@ -2,8 +2,14 @@ package local
 
 import (
 	"bdaggregator/internal/config"
+	"bdaggregator/internal/storage/compression"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 )
 
 type LocalStorage struct {
@@ -14,11 +20,83 @@ func NewLocalStorage(cfg *config.Config) *LocalStorage {
 	return &LocalStorage{cfg: cfg}
 }
 
-func (l *LocalStorage) Download() (io.Reader, error) {
-	file, err := os.Open(l.cfg.LocalStoragePath)
+// The local path may be a single file, a directory (all files below it are
+// listed) or a glob understood by filepath.Glob
+func (l *LocalStorage) List() ([]string, error) {
+	localPath := l.cfg.LocalStoragePath
+
+	if strings.ContainsAny(localPath, "*?[") {
+		matches, err := filepath.Glob(localPath)
+		if err != nil {
+			return nil, err
+		}
+		return filterFiles(localPath, matches)
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{localPath}, nil
+	}
+
+	var names []string
+	err = filepath.WalkDir(localPath, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.Type().IsRegular() {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return filterFiles(localPath, names)
+}
+
+func (l *LocalStorage) Open(name string) (io.ReadCloser, error) {
+	file, err := os.Open(name)
 	if err != nil {
 		return nil, err
 	}
 
-	return file, nil
+	return compression.NewReader(file, name, "")
+}
+
+func (l *LocalStorage) Create(name string) (io.WriteCloser, error) {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return nil, err
+	}
+
+	return os.Create(name)
+}
+
+// Nothing to release, files are closed by the readers and writers
+func (l *LocalStorage) Close() error {
+	return nil
+}
+
+// Keep regular files only and return them in a stable order
+func filterFiles(localPath string, candidates []string) ([]string, error) {
+	var names []string
+	for _, name := range candidates {
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().IsRegular() {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no files found matching %s", localPath)
+	}
+	sort.Strings(names)
+
+	return names, nil
 }
@@ -2,14 +2,16 @@ package local
 
 import (
 	"bdaggregator/internal/config"
+	"compress/gzip"
 	"io"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func TestLocalStorage_Download(t *testing.T) {
+func TestLocalStorage_Open(t *testing.T) {
 	tempFile, err := os.CreateTemp("", "testfile")
 	assert.NoError(t, err, "Expected no error creating temporary file")
 	defer os.Remove(tempFile.Name())
@@ -25,22 +27,108 @@ func TestLocalStorage_Download(t *testing.T) {
 
 	localStorage := NewLocalStorage(cfg)
 
-	reader, err := localStorage.Download()
-	assert.NoError(t, err, "Expected no error from Download")
+	names, err := localStorage.List()
+	assert.NoError(t, err, "Expected no error from List")
+	assert.Equal(t, []string{tempFile.Name()}, names, "Expected the configured file to be listed")
+
+	reader, err := localStorage.Open(names[0])
+	assert.NoError(t, err, "Expected no error from Open")
+	defer reader.Close()
 
 	data, err := io.ReadAll(reader)
 	assert.NoError(t, err, "Expected no error reading data")
 	assert.Equal(t, expectedData, string(data), "Expected data to match written data")
 }
 
-func TestLocalStorage_Download_FileNotFound(t *testing.T) {
+func TestLocalStorage_Open_Gzip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sample_data.csv.gz")
+	file, err := os.Create(path)
+	assert.NoError(t, err, "Expected no error creating compressed file")
+
+	expectedData := "sample data"
+	writer := gzip.NewWriter(file)
+	_, err = writer.Write([]byte(expectedData))
+	assert.NoError(t, err, "Expected no error writing compressed data")
+	assert.NoError(t, writer.Close())
+	assert.NoError(t, file.Close())
+
+	reader, err := NewLocalStorage(&config.Config{LocalStoragePath: path}).Open(path)
+	assert.NoError(t, err, "Expected no error from Open")
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err, "Expected no error reading data")
+	assert.Equal(t, expectedData, string(data), "Expected data to be decompressed")
+}
+
+func TestLocalStorage_Open_FileNotFound(t *testing.T) {
 	cfg := &config.Config{
 		LocalStoragePath: "non_existent_file.txt",
 	}
 
 	localStorage := NewLocalStorage(cfg)
 
-	_, err := localStorage.Download()
+	_, err := localStorage.List()
+	assert.Error(t, err, "Expected an error due to non-existent file")
+	assert.Contains(t, err.Error(), "no such file or directory", "Expected file not found error")
+
+	_, err = localStorage.Open(cfg.LocalStoragePath)
 	assert.Error(t, err, "Expected an error due to non-existent file")
 	assert.Contains(t, err.Error(), "no such file or directory", "Expected file not found error")
 }
+
+func TestLocalStorage_List(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"2024-04-16.csv", "2024-04-15.csv", "notes.txt", "nested/2024-04-17.csv"} {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, os.WriteFile(path, []byte("data"), 0644))
+	}
+
+	tests := []struct {
+		name      string
+		localPath string
+		expected  []string
+	}{
+		{
+			name:      "Directory",
+			localPath: dir,
+			expected:  []string{"2024-04-15.csv", "2024-04-16.csv", "nested/2024-04-17.csv", "notes.txt"},
+		},
+		{
+			name:      "Glob",
+			localPath: filepath.Join(dir, "*.csv"),
+			expected:  []string{"2024-04-15.csv", "2024-04-16.csv"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			names, err := NewLocalStorage(&config.Config{LocalStoragePath: tt.localPath}).List()
+			assert.NoError(t, err, "Expected no error from List")
+
+			var expected []string
+			for _, name := range tt.expected {
+				expected = append(expected, filepath.Join(dir, name))
+			}
+			assert.Equal(t, expected, names, "Expected listed files to match")
+		})
+	}
+
+	_, err := NewLocalStorage(&config.Config{LocalStoragePath: filepath.Join(dir, "*.parquet")}).List()
+	assert.Error(t, err, "Expected an error when nothing matches the glob")
+}
+
+func TestLocalStorage_Create(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rejected", "dead_letters.ndjson")
+
+	writer, err := NewLocalStorage(&config.Config{}).Create(path)
+	assert.NoError(t, err, "Expected no error from Create")
+	_, err = writer.Write([]byte("sample data"))
+	assert.NoError(t, err, "Expected no error writing data")
+	assert.NoError(t, writer.Close())
+
+	data, err := os.ReadFile(path)
+	assert.NoError(t, err, "Expected the file to exist")
+	assert.Equal(t, "sample data", string(data))
+}
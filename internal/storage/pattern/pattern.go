@@ -0,0 +1,38 @@
+package pattern
+
+import (
+	"path"
+	"strings"
+)
+
+// Object paths configured for a storage backend can take three forms:
+//   - "exports/2024-04-15.csv"  a single object
+//   - "exports/"                every object under the prefix
+//   - "exports/2024-04-*.csv"   every object matching the glob (path.Match syntax)
+
+// Check whether the object path selects more than a single object
+func IsPattern(objectPath string) bool {
+	return strings.HasSuffix(objectPath, "/") || strings.ContainsAny(objectPath, "*?[")
+}
+
+// Return the literal part of the object path that can be used to narrow a
+// listing request before the names are filtered with Match
+func Prefix(objectPath string) string {
+	if i := strings.IndexAny(objectPath, "*?["); i >= 0 {
+		return objectPath[:i]
+	}
+	return objectPath
+}
+
+// Check whether an object name is selected by the object path
+func Match(objectPath, name string) bool {
+	switch {
+	case strings.ContainsAny(objectPath, "*?["):
+		matched, err := path.Match(objectPath, name)
+		return err == nil && matched
+	case strings.HasSuffix(objectPath, "/"):
+		return strings.HasPrefix(name, objectPath) && !strings.HasSuffix(name, "/")
+	default:
+		return name == objectPath
+	}
+}
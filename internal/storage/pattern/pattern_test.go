@@ -0,0 +1,41 @@
+package pattern
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsPattern(t *testing.T) {
+	assert.False(t, IsPattern("exports/2024-04-15.csv"))
+	assert.True(t, IsPattern("exports/"))
+	assert.True(t, IsPattern("exports/2024-04-*.csv"))
+}
+
+func TestPrefix(t *testing.T) {
+	assert.Equal(t, "exports/2024-04-15.csv", Prefix("exports/2024-04-15.csv"))
+	assert.Equal(t, "exports/", Prefix("exports/"))
+	assert.Equal(t, "exports/2024-04-", Prefix("exports/2024-04-*.csv"))
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		objectPath string
+		name       string
+		expected   bool
+	}{
+		{"exports/a.csv", "exports/a.csv", true},
+		{"exports/a.csv", "exports/a.csv.bak", false},
+		{"exports/", "exports/a.csv", true},
+		{"exports/", "exports/2024/a.csv", true},
+		{"exports/", "exports/2024/", false},
+		{"exports/", "other/a.csv", false},
+		{"exports/2024-04-*.csv", "exports/2024-04-15.csv", true},
+		{"exports/2024-04-*.csv", "exports/2024-05-01.csv", false},
+		{"exports/*.csv", "exports/2024/a.csv", false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, Match(tt.objectPath, tt.name), "Match(%q, %q)", tt.objectPath, tt.name)
+	}
+}
@@ -0,0 +1,160 @@
+package s3
+
+import (
+	"bdaggregator/internal/config"
+	"bdaggregator/internal/storage/compression"
+	"bdaggregator/internal/storage/pattern"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	awss3 "github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// Region used when neither SEQUENCE_S3_REGION nor the AWS config set one. Most S3-compatible
+// servers (MinIO, Ceph, R2) accept any region for signing.
+const defaultRegion = "us-east-1"
+
+type S3Storage struct {
+	cfg    *config.Config
+	client *awss3.Client
+}
+
+func NewS3Storage(cfg *config.Config) *S3Storage {
+	return &S3Storage{cfg: cfg}
+}
+
+func (s *S3Storage) List() ([]string, error) {
+	objectPath := s.cfg.S3Object
+	if !pattern.IsPattern(objectPath) {
+		return []string{objectPath}, nil
+	}
+
+	ctx := context.Background()
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	paginator := awss3.NewListObjectsV2Paginator(client, &awss3.ListObjectsV2Input{
+		Bucket: aws.String(s.cfg.S3Bucket),
+		Prefix: aws.String(pattern.Prefix(objectPath)),
+	})
+
+	var names []string
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects in bucket %s: %v", s.cfg.S3Bucket, err)
+		}
+		for _, object := range page.Contents {
+			name := aws.ToString(object.Key)
+			if pattern.Match(objectPath, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no objects found matching %s in bucket %s", objectPath, s.cfg.S3Bucket)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+func (s *S3Storage) Open(name string) (io.ReadCloser, error) {
+	ctx := context.Background()
+	client, err := s.getClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out, err := client.GetObject(ctx, &awss3.GetObjectInput{
+		Bucket: aws.String(s.cfg.S3Bucket),
+		Key:    aws.String(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return compression.NewReader(out.Body, name, aws.ToString(out.ContentEncoding))
+}
+
+// The object is buffered in memory and uploaded when the writer is closed,
+// PutObject needs to know the content length up front
+func (s *S3Storage) Create(name string) (io.WriteCloser, error) {
+	return &objectWriter{storage: s, name: name}, nil
+}
+
+type objectWriter struct {
+	storage *S3Storage
+	name    string
+	buf     bytes.Buffer
+}
+
+func (w *objectWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func (w *objectWriter) Close() error {
+	ctx := context.Background()
+	client, err := w.storage.getClient(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = client.PutObject(ctx, &awss3.PutObjectInput{
+		Bucket: aws.String(w.storage.cfg.S3Bucket),
+		Key:    aws.String(w.name),
+		Body:   bytes.NewReader(w.buf.Bytes()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload object %s: %v", w.name, err)
+	}
+	return nil
+}
+
+// The S3 client holds no connections to release
+func (s *S3Storage) Close() error {
+	return nil
+}
+
+// Build an S3 client from config on first use. A custom endpoint and path-style
+// addressing allow talking to S3-compatible servers. Credentials come from the
+// default AWS chain (environment, shared config, IAM role), static credentials
+// override it when both keys are set and anonymous access has to be asked for.
+func (s *S3Storage) getClient(ctx context.Context) (*awss3.Client, error) {
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	awsCfg, err := awsconfig.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+	if s.cfg.S3Region != "" {
+		awsCfg.Region = s.cfg.S3Region
+	}
+	if awsCfg.Region == "" {
+		awsCfg.Region = defaultRegion
+	}
+
+	switch {
+	case s.cfg.S3AccessKeyID != "" && s.cfg.S3SecretAccessKey != "":
+		awsCfg.Credentials = credentials.NewStaticCredentialsProvider(s.cfg.S3AccessKeyID, s.cfg.S3SecretAccessKey, "")
+	case s.cfg.S3Anonymous:
+		awsCfg.Credentials = aws.AnonymousCredentials{}
+	}
+
+	s.client = awss3.NewFromConfig(awsCfg, func(options *awss3.Options) {
+		options.UsePathStyle = s.cfg.S3UsePathStyle
+		if s.cfg.S3Endpoint != "" {
+			options.BaseEndpoint = aws.String(s.cfg.S3Endpoint)
+		}
+	})
+
+	return s.client, nil
+}
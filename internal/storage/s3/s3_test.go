@@ -0,0 +1,200 @@
+package s3
+
+import (
+	"bdaggregator/internal/config"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3Storage_Open(t *testing.T) {
+	expectedData := "sample data"
+
+	// Simulate an S3-compatible server using path-style addressing
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/test-bucket/exports/sample_data.csv", r.URL.Path)
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test-key/"),
+			"Expected request to be signed with static credentials")
+
+		w.Header().Set("Content-Type", "text/csv")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(expectedData))
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		S3Bucket:          "test-bucket",
+		S3Object:          "exports/sample_data.csv",
+		S3Endpoint:        mockServer.URL,
+		S3UsePathStyle:    true,
+		S3AccessKeyID:     "test-key",
+		S3SecretAccessKey: "test-secret",
+	}
+
+	s3Storage := NewS3Storage(cfg)
+
+	names, err := s3Storage.List()
+	assert.NoError(t, err, "Expected no error from List")
+	assert.Equal(t, []string{"exports/sample_data.csv"}, names, "Expected the configured object without a listing request")
+
+	reader, err := s3Storage.Open(names[0])
+	assert.NoError(t, err, "Expected no error from Open")
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err, "Expected no error reading data")
+	assert.Equal(t, expectedData, string(data), "Expected data to match served object")
+}
+
+func TestS3Storage_Open_ContentEncoding(t *testing.T) {
+	expectedData := "sample data"
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write([]byte(expectedData))
+	writer.Close()
+
+	// The object name gives no hint, the Content-Encoding metadata does
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusOK)
+		w.Write(compressed.Bytes())
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		S3Bucket:       "test-bucket",
+		S3Endpoint:     mockServer.URL,
+		S3UsePathStyle: true,
+		S3Anonymous:    true,
+	}
+
+	reader, err := NewS3Storage(cfg).Open("exports/sample_data")
+	assert.NoError(t, err, "Expected no error from Open")
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err, "Expected no error reading data")
+	assert.Equal(t, expectedData, string(data), "Expected data to be decompressed")
+}
+
+func TestS3Storage_Open_NotFound(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>`))
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		S3Bucket:       "test-bucket",
+		S3Endpoint:     mockServer.URL,
+		S3UsePathStyle: true,
+		S3Anonymous:    true,
+	}
+
+	_, err := NewS3Storage(cfg).Open("missing.csv")
+	assert.Error(t, err, "Expected an error for a missing object")
+	assert.Contains(t, err.Error(), "NoSuchKey", "Expected S3 error code in message")
+}
+
+func TestS3Storage_List(t *testing.T) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/test-bucket", r.URL.Path)
+		assert.Equal(t, "2", r.URL.Query().Get("list-type"))
+		assert.Equal(t, "exports/2024-04-", r.URL.Query().Get("prefix"))
+
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<ListBucketResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Name>test-bucket</Name>
+  <Prefix>exports/2024-04-</Prefix>
+  <KeyCount>3</KeyCount>
+  <IsTruncated>false</IsTruncated>
+  <Contents><Key>exports/2024-04-16.csv</Key><Size>4</Size></Contents>
+  <Contents><Key>exports/2024-04-15.csv</Key><Size>4</Size></Contents>
+  <Contents><Key>exports/2024-04-15.json</Key><Size>4</Size></Contents>
+</ListBucketResult>`))
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		S3Bucket:       "test-bucket",
+		S3Object:       "exports/2024-04-*.csv",
+		S3Endpoint:     mockServer.URL,
+		S3UsePathStyle: true,
+		S3Anonymous:    true,
+	}
+
+	names, err := NewS3Storage(cfg).List()
+	assert.NoError(t, err, "Expected no error from List")
+	assert.Equal(t, []string{"exports/2024-04-15.csv", "exports/2024-04-16.csv"}, names, "Expected matching objects sorted by name")
+}
+
+func TestS3Storage_Create(t *testing.T) {
+	var uploaded []byte
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "/test-bucket/rejected/dead_letters.ndjson", r.URL.Path)
+
+		uploaded, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		S3Bucket:          "test-bucket",
+		S3Endpoint:        mockServer.URL,
+		S3UsePathStyle:    true,
+		S3AccessKeyID:     "test-key",
+		S3SecretAccessKey: "test-secret",
+	}
+
+	writer, err := NewS3Storage(cfg).Create("rejected/dead_letters.ndjson")
+	assert.NoError(t, err, "Expected no error from Create")
+	_, err = writer.Write([]byte("sample data"))
+	assert.NoError(t, err, "Expected no error writing data")
+	assert.Nil(t, uploaded, "Expected nothing to be uploaded before Close")
+
+	assert.NoError(t, writer.Close(), "Expected no error uploading the object")
+	assert.Equal(t, "sample data", string(uploaded))
+}
+
+func TestS3Storage_Open_DefaultCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(t.TempDir(), "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(t.TempDir(), "credentials"))
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=env-key/"),
+			"Expected request to be signed with the credentials of the default AWS chain")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("sample data"))
+	}))
+	defer mockServer.Close()
+
+	cfg := &config.Config{
+		S3Bucket:       "test-bucket",
+		S3Endpoint:     mockServer.URL,
+		S3UsePathStyle: true,
+	}
+
+	reader, err := NewS3Storage(cfg).Open("sample_data.csv")
+	assert.NoError(t, err, "Expected no error from Open")
+	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "sample data", string(data))
+}
@@ -2,7 +2,15 @@ package storage
 
 import "io"
 
-// Define methods for downloading data
+// Define methods for listing and downloading data. The configured object path
+// of each backend may point to a single object, a prefix or a glob.
 type Storage interface {
-	Download() (io.Reader, error)
+	// List returns the names of all objects matching the configured path, sorted by name
+	List() ([]string, error)
+	// Open returns a reader for a single object returned by List
+	Open(name string) (io.ReadCloser, error)
+	// Create returns a writer for a new object, the object is complete once the writer is closed
+	Create(name string) (io.WriteCloser, error)
+	// Close releases the client of the backend, objects can't be opened afterwards
+	Close() error
 }